@@ -0,0 +1,88 @@
+package plugindemo
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ActiveHours is the daily window, from Start to End formatted as "15:04" in Timezone, UTC when empty,
+// during which the requests are forwarded. A window whose End is before its Start spans midnight.
+type ActiveHours struct {
+	Start    string
+	End      string
+	Timezone string
+}
+
+// defaultSleepingPage is the page answered outside the active hours when SleepingPage is not set.
+const defaultSleepingPage = "<!DOCTYPE html><title>Sleeping</title><p>The service is sleeping, come back during its active hours.</p>"
+
+// activeHours is a parsed active hours window, its bounds being offsets since midnight.
+// A nil window is disabled and contains all times.
+type activeHours struct {
+	start    time.Duration
+	end      time.Duration
+	location *time.Location
+}
+
+func newActiveHours(config *Config) (*activeHours, error) {
+	hours := config.ActiveHours
+	if hours.Start == "" && hours.End == "" {
+		return nil, nil
+	}
+	start, err := parseTimeOfDay("active hours start", hours.Start)
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseTimeOfDay("active hours end", hours.End)
+	if err != nil {
+		return nil, err
+	}
+	if start == end {
+		return nil, fmt.Errorf("incorrect value for active hours (%s-%s)", hours.Start, hours.End)
+	}
+	location, err := time.LoadLocation(hours.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("incorrect value for active hours timezone (%v)", hours.Timezone)
+	}
+	if status := config.SleepingStatus; status != 0 && !validStatus(status) {
+		return nil, fmt.Errorf("incorrect value for sleeping status (%d)", config.SleepingStatus)
+	}
+
+	return &activeHours{start: start, end: end, location: location}, nil
+}
+
+// parseTimeOfDay parses value, formatted as "15:04", into an offset since midnight.
+func parseTimeOfDay(name, value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("incorrect value for %s (%v)", name, value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// contains reports whether now is within the window.
+func (a *activeHours) contains(now time.Time) bool {
+	if a == nil {
+		return true
+	}
+
+	now = now.In(a.location)
+	offset := time.Duration(now.Hour())*time.Hour + time.Duration(now.Minute())*time.Minute +
+		time.Duration(now.Second())*time.Second
+	if a.start < a.end {
+		return offset >= a.start && offset < a.end
+	}
+	return offset >= a.start || offset < a.end
+}
+
+// isSleeping reports whether the requests must get the sleeping page, being outside the active hours.
+func (r *Retry) isSleeping() bool {
+	return !r.activeHours.contains(r.clock.Now())
+}
+
+func (r *Retry) writeSleeping(rw http.ResponseWriter) {
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.WriteHeader(r.sleepingStatus)
+	_, _ = rw.Write([]byte(r.sleepingPage))
+}
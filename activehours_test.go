@@ -0,0 +1,176 @@
+package plugindemo_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/madshargreave/traefik-sleep"
+)
+
+func TestActiveHours(t *testing.T) {
+	tests := []struct {
+		desc    string
+		hours   plugindemo.ActiveHours
+		now     time.Time
+		expCode int
+	}{
+		{
+			desc:    "inside the window",
+			hours:   plugindemo.ActiveHours{Start: "09:00", End: "18:00"},
+			now:     time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC),
+			expCode: http.StatusOK,
+		},
+		{
+			desc:    "at the end of the window",
+			hours:   plugindemo.ActiveHours{Start: "09:00", End: "18:00"},
+			now:     time.Date(2024, 3, 4, 18, 0, 0, 0, time.UTC),
+			expCode: http.StatusServiceUnavailable,
+		},
+		{
+			desc:    "before the window",
+			hours:   plugindemo.ActiveHours{Start: "09:00", End: "18:00"},
+			now:     time.Date(2024, 3, 4, 8, 59, 59, 0, time.UTC),
+			expCode: http.StatusServiceUnavailable,
+		},
+		{
+			desc:    "inside the window of the timezone",
+			hours:   plugindemo.ActiveHours{Start: "09:00", End: "18:00", Timezone: "America/New_York"},
+			now:     time.Date(2024, 3, 4, 20, 0, 0, 0, time.UTC),
+			expCode: http.StatusOK,
+		},
+		{
+			desc:    "outside the window of the timezone",
+			hours:   plugindemo.ActiveHours{Start: "09:00", End: "18:00", Timezone: "America/New_York"},
+			now:     time.Date(2024, 3, 4, 10, 0, 0, 0, time.UTC),
+			expCode: http.StatusServiceUnavailable,
+		},
+		{
+			desc:    "inside a window spanning midnight",
+			hours:   plugindemo.ActiveHours{Start: "22:00", End: "06:00"},
+			now:     time.Date(2024, 3, 4, 2, 0, 0, 0, time.UTC),
+			expCode: http.StatusOK,
+		},
+		{
+			desc:    "outside a window spanning midnight",
+			hours:   plugindemo.ActiveHours{Start: "22:00", End: "06:00"},
+			now:     time.Date(2024, 3, 4, 12, 0, 0, 0, time.UTC),
+			expCode: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			cfg := plugindemo.CreateConfig()
+			cfg.Attempts = 1
+			cfg.ActiveHours = test.hours
+
+			calls := 0
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+			})
+
+			handler, err := plugindemo.New(context.Background(), next, cfg, "demo-plugin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			handler.(*plugindemo.Retry).SetClock(plugindemo.NewFakeClock(test.now))
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+			assertStatus(t, recorder, test.expCode)
+			if test.expCode == http.StatusOK {
+				if calls != 1 {
+					t.Errorf("invalid number of calls: %d", calls)
+				}
+				return
+			}
+			if calls != 0 {
+				t.Errorf("request forwarded outside the active hours: %d", calls)
+			}
+			if !strings.Contains(recorder.Body.String(), "sleeping") {
+				t.Errorf("invalid body: %q", recorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestSleepingPage(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 1
+	cfg.ActiveHours = plugindemo.ActiveHours{Start: "09:00", End: "18:00"}
+	cfg.SleepingStatus = http.StatusTeapot
+	cfg.SleepingPage = "<p>back at nine</p>"
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		t.Error("request forwarded outside the active hours")
+	})
+
+	handler, err := plugindemo.New(context.Background(), next, cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.(*plugindemo.Retry).SetClock(plugindemo.NewFakeClock(time.Date(2024, 3, 4, 20, 0, 0, 0, time.UTC)))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+	assertStatus(t, recorder, http.StatusTeapot)
+	assertHeader(t, recorder.Header(), "Content-Type", "text/html; charset=utf-8")
+	if recorder.Body.String() != "<p>back at nine</p>" {
+		t.Errorf("invalid body: %q", recorder.Body.String())
+	}
+}
+
+func TestInvalidActiveHours(t *testing.T) {
+	tests := []struct {
+		desc   string
+		hours  plugindemo.ActiveHours
+		status int
+	}{
+		{desc: "invalid start", hours: plugindemo.ActiveHours{Start: "9am", End: "18:00"}},
+		{desc: "missing end", hours: plugindemo.ActiveHours{Start: "09:00"}},
+		{desc: "empty window", hours: plugindemo.ActiveHours{Start: "09:00", End: "09:00"}},
+		{desc: "unknown timezone", hours: plugindemo.ActiveHours{Start: "09:00", End: "18:00", Timezone: "Nowhere/Town"}},
+		{desc: "invalid status", hours: plugindemo.ActiveHours{Start: "09:00", End: "18:00"}, status: 1000},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			cfg := plugindemo.CreateConfig()
+			cfg.Attempts = 1
+			cfg.ActiveHours = test.hours
+			if test.status != 0 {
+				cfg.SleepingStatus = test.status
+			}
+
+			if err := cfg.Validate(); err == nil {
+				t.Error("expected a validation error")
+			}
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+			if _, err := plugindemo.New(context.Background(), next, cfg, "demo-plugin"); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestSleepingDefaultStatus(t *testing.T) {
+	// A configuration not made by CreateConfig answers the requests outside the active hours with a 503.
+	cfg := &plugindemo.Config{Attempts: 1, ActiveHours: plugindemo.ActiveHours{Start: "09:00", End: "18:00"}}
+	handler, err := plugindemo.New(context.Background(), http.NotFoundHandler(), cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.(*plugindemo.Retry).SetClock(plugindemo.NewFakeClock(time.Date(2024, 3, 4, 20, 0, 0, 0, time.UTC)))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+	assertStatus(t, recorder, http.StatusServiceUnavailable)
+}
@@ -0,0 +1,82 @@
+package plugindemo
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// adaptiveAlpha is the weight of the last request in the rolling failure rate of an adaptive backoff.
+const adaptiveAlpha = 0.2
+
+// adaptiveBackoff grows the backoff of all the requests while the backend keeps failing,
+// to give it more room to recover. A nil adaptive backoff is disabled and keeps the backoff as is.
+type adaptiveBackoff struct {
+	threshold float64
+	maxFactor float64
+
+	mu sync.Mutex
+	// rate is the exponentially weighted failure rate of the requests.
+	rate float64
+	// factor multiplies the backoff, between 1 and the max factor.
+	factor float64
+}
+
+func newAdaptiveBackoff(config *Config) (*adaptiveBackoff, error) {
+	if !config.AdaptiveBackoff {
+		return nil, nil
+	}
+	if config.AdaptiveBackoffThreshold <= 0 || config.AdaptiveBackoffThreshold > 1 {
+		return nil, fmt.Errorf("incorrect value for adaptive backoff threshold (%v)", config.AdaptiveBackoffThreshold)
+	}
+	if config.AdaptiveBackoffMaxFactor < 1 {
+		return nil, fmt.Errorf("incorrect value for adaptive backoff max factor (%v)", config.AdaptiveBackoffMaxFactor)
+	}
+
+	return &adaptiveBackoff{
+		threshold: config.AdaptiveBackoffThreshold,
+		maxFactor: config.AdaptiveBackoffMaxFactor,
+		factor:    1,
+	}, nil
+}
+
+// record accounts for the outcome of a request: the factor doubles, up to the max factor,
+// on each failure while the failure rate is above the threshold, and halves on each success.
+func (a *adaptiveBackoff) record(failed bool) {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	outcome := 0.0
+	if failed {
+		outcome = 1
+	}
+	a.rate += adaptiveAlpha * (outcome - a.rate)
+
+	switch {
+	case failed && a.rate > a.threshold:
+		a.factor *= 2
+		if a.factor > a.maxFactor {
+			a.factor = a.maxFactor
+		}
+	case !failed:
+		a.factor /= 2
+		if a.factor < 1 {
+			a.factor = 1
+		}
+	}
+}
+
+// scale returns the backoff d multiplied by the current factor, which may exceed the backoff max.
+func (a *adaptiveBackoff) scale(d time.Duration) time.Duration {
+	if a == nil {
+		return d
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return time.Duration(float64(d) * a.factor)
+}
@@ -0,0 +1,162 @@
+package plugindemo
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Backoff strategies.
+const (
+	backoffConstant    = "constant"
+	backoffLinear      = "linear"
+	backoffExponential = "exponential"
+)
+
+var (
+	randomMu sync.Mutex
+	random   = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// randomFloat64 returns a pseudo-random number in [0.0,1.0) from the package source.
+func randomFloat64() float64 {
+	randomMu.Lock()
+	defer randomMu.Unlock()
+	return random.Float64()
+}
+
+// backoff is the configuration of the wait between two attempts.
+type backoff struct {
+	base     time.Duration
+	max      time.Duration
+	strategy string
+	jitter   float64
+}
+
+func newBackoff(config *Config) (backoff, error) {
+	if config.Jitter < 0 || config.Jitter > 1 {
+		return backoff{}, fmt.Errorf("incorrect value for jitter (%v)", config.Jitter)
+	}
+	if err := validateBackoffStrategy(config.BackoffStrategy); err != nil {
+		return backoff{}, err
+	}
+
+	base, err := parseDuration("backoff base", config.BackoffBase)
+	if err != nil {
+		return backoff{}, err
+	}
+	max, err := parseDuration("backoff max", config.BackoffMax)
+	if err != nil {
+		return backoff{}, err
+	}
+
+	return backoff{base: base, max: max, strategy: config.BackoffStrategy, jitter: config.Jitter}, nil
+}
+
+func validateBackoffStrategy(strategy string) error {
+	switch strategy {
+	case "", backoffConstant, backoffLinear, backoffExponential:
+		return nil
+	default:
+		return fmt.Errorf("incorrect value for backoff strategy (%s)", strategy)
+	}
+}
+
+// next returns the wait before the given attempt.
+// The first attempt is never delayed, the first retry waits
+// for the backoff base, and each following retry keeps it, increases it by the base,
+// or doubles it with the constant, linear and exponential strategies respectively.
+// The result is then randomized by the configured jitter.
+func (b backoff) next(attempt int) time.Duration {
+	if attempt <= 1 || b.base <= 0 {
+		return 0
+	}
+
+	var d time.Duration
+	switch b.strategy {
+	case backoffConstant:
+		d = b.clamp(b.base)
+	case backoffLinear:
+		d = b.linear(attempt)
+	default:
+		d = b.exponential(attempt)
+	}
+	if b.jitter > 0 {
+		d = time.Duration(float64(d) * (1 + b.jitter*(2*randomFloat64()-1)))
+	}
+	return d
+}
+
+// exponential returns the backoff base doubled for each retry after the first one,
+// clamped to the backoff max.
+func (b backoff) exponential(attempt int) time.Duration {
+	d := b.base
+	for i := 2; i < attempt; i++ {
+		if (b.max > 0 && d >= b.max) || d > math.MaxInt64/2 {
+			break
+		}
+		d *= 2
+	}
+
+	return b.clamp(d)
+}
+
+// linear returns the backoff base multiplied by the number of retries so far,
+// clamped to the backoff max.
+func (b backoff) linear(attempt int) time.Duration {
+	retries := time.Duration(attempt - 1)
+	if b.base > math.MaxInt64/retries {
+		return b.clamp(math.MaxInt64)
+	}
+	return b.clamp(b.base * retries)
+}
+
+// clamp returns d, clamped to the backoff max.
+func (b backoff) clamp(d time.Duration) time.Duration {
+	if b.max > 0 && d > b.max {
+		return b.max
+	}
+	return d
+}
+
+// retryDelay returns the wait before the given attempt made under p, following the Retry-After header
+// of the previous response when present, clamped to the configured maximum,
+// or the backoff of p grown by the adaptive backoff otherwise.
+func (r *Retry) retryDelay(p *policy, attempt int, previous *statusWriter) time.Duration {
+	d, ok := retryAfter(previous.Header(), r.clock.Now())
+	if !ok {
+		return r.adaptive.scale(p.backoff.next(attempt))
+	}
+	if r.maxRetryAfter > 0 && d > r.maxRetryAfter {
+		return r.maxRetryAfter
+	}
+	return d
+}
+
+// retryAfter parses the Retry-After header, in either its delay-seconds or HTTP-date form.
+func retryAfter(header http.Header, now time.Time) (time.Duration, bool) {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	if d := date.Sub(now); d > 0 {
+		return d, true
+	}
+	return 0, true
+}
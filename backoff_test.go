@@ -0,0 +1,304 @@
+package plugindemo_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/madshargreave/traefik-sleep"
+)
+
+func TestBackoff(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 3
+	cfg.BackoffBase = "100ms"
+
+	calls := 0
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	start := time.Now()
+	recorder := serve(t, cfg, next, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+	elapsed := time.Since(start)
+
+	assertStatus(t, recorder, http.StatusServiceUnavailable)
+	if calls != 3 {
+		t.Errorf("invalid number of attempts: %d", calls)
+	}
+	if elapsed < 300*time.Millisecond || elapsed > time.Second {
+		t.Errorf("invalid cumulative backoff: %v", elapsed)
+	}
+}
+
+func TestBackoffFakeClock(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 5
+	cfg.BackoffBase = "1s"
+	cfg.BackoffMax = "5s"
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	handler, err := plugindemo.New(context.Background(), next, cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := plugindemo.NewFakeClock(time.Now())
+	handler.(*plugindemo.Retry).SetClock(clock)
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second}
+	sleeps := clock.Sleeps()
+	if len(sleeps) != len(expected) {
+		t.Fatalf("invalid sleeps: %v", sleeps)
+	}
+	for i, want := range expected {
+		if sleeps[i] != want {
+			t.Errorf("sleep %d: got %v, want %v", i, sleeps[i], want)
+		}
+	}
+}
+
+func TestAdaptiveBackoff(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 2
+	cfg.BackoffBase = "100ms"
+	cfg.BackoffStrategy = "constant"
+	cfg.AdaptiveBackoff = true
+
+	failing := true
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if failing {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+
+	handler, err := plugindemo.New(context.Background(), next, cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := plugindemo.NewFakeClock(time.Now())
+	handler.(*plugindemo.Retry).SetClock(clock)
+	request := func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+	}
+
+	// The factor doubles once the rolling failure rate exceeds the threshold, up to the max factor.
+	for i := 0; i < 8; i++ {
+		request()
+	}
+	// The factor halves on each success, the next failure being back to the backoff base.
+	failing = false
+	for i := 0; i < 4; i++ {
+		request()
+	}
+	failing = true
+	request()
+
+	ms := time.Millisecond
+	expected := []time.Duration{100 * ms, 100 * ms, 100 * ms, 100 * ms, 200 * ms, 400 * ms, 800 * ms, 800 * ms, 100 * ms}
+	sleeps := clock.Sleeps()
+	if len(sleeps) != len(expected) {
+		t.Fatalf("invalid sleeps: %v", sleeps)
+	}
+	for i, want := range expected {
+		if sleeps[i] != want {
+			t.Errorf("sleep %d: got %v, want %v", i, sleeps[i], want)
+		}
+	}
+}
+
+func TestInvalidAdaptiveBackoff(t *testing.T) {
+	for _, threshold := range []float64{0, 1.5} {
+		cfg := plugindemo.CreateConfig()
+		cfg.Attempts = 1
+		cfg.AdaptiveBackoff = true
+		cfg.AdaptiveBackoffThreshold = threshold
+
+		if _, err := plugindemo.New(context.Background(), http.NotFoundHandler(), cfg, "demo-plugin"); err == nil {
+			t.Errorf("expected an error for threshold %v", threshold)
+		}
+	}
+}
+
+func TestBackoffCanceled(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 2
+	cfg.BackoffBase = "1m"
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	start := time.Now()
+	recorder := serve(t, cfg, next, httptest.NewRequest(http.MethodGet, "http://localhost", nil).WithContext(ctx))
+
+	assertStatus(t, recorder, 499)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("backoff was not interrupted: %v", elapsed)
+	}
+}
+
+func TestNextBackoff(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 10
+	cfg.BackoffBase = "100ms"
+	cfg.BackoffMax = "500ms"
+
+	handler, err := plugindemo.New(context.Background(), http.NotFoundHandler(), cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	retry := handler.(*plugindemo.Retry)
+
+	expected := []time.Duration{0, 100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 500 * time.Millisecond, 500 * time.Millisecond}
+	for i, want := range expected {
+		attempt := i + 1
+		if got := retry.NextBackoff(attempt); got != want {
+			t.Errorf("attempt %d: got backoff %v, want %v", attempt, got, want)
+		}
+	}
+}
+
+func TestNextBackoffStrategies(t *testing.T) {
+	tests := []struct {
+		strategy string
+		expected []time.Duration
+	}{
+		{strategy: "constant", expected: []time.Duration{100 * time.Millisecond, 100 * time.Millisecond, 100 * time.Millisecond}},
+		{strategy: "linear", expected: []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond}},
+		{strategy: "exponential", expected: []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond}},
+		{strategy: "", expected: []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond}},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.strategy, func(t *testing.T) {
+			cfg := plugindemo.CreateConfig()
+			cfg.Attempts = 4
+			cfg.BackoffBase = "100ms"
+			cfg.BackoffStrategy = test.strategy
+
+			handler, err := plugindemo.New(context.Background(), http.NotFoundHandler(), cfg, "demo-plugin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			retry := handler.(*plugindemo.Retry)
+
+			for i, want := range test.expected {
+				attempt := i + 2
+				if got := retry.NextBackoff(attempt); got != want {
+					t.Errorf("attempt %d: got backoff %v, want %v", attempt, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestInvalidBackoffStrategy(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 2
+	cfg.BackoffStrategy = "random"
+
+	if _, err := plugindemo.New(context.Background(), http.NotFoundHandler(), cfg, "demo-plugin"); err == nil {
+		t.Error("expected an error for an invalid backoff strategy")
+	}
+}
+
+func TestNextBackoffJitter(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 2
+	cfg.BackoffBase = "100ms"
+	cfg.Jitter = 0.5
+
+	handler, err := plugindemo.New(context.Background(), http.NotFoundHandler(), cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	retry := handler.(*plugindemo.Retry)
+
+	for i := 0; i < 100; i++ {
+		got := retry.NextBackoff(2)
+		if got < 50*time.Millisecond || got > 150*time.Millisecond {
+			t.Fatalf("jittered backoff out of range: %v", got)
+		}
+	}
+}
+
+func TestInvalidJitter(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 2
+	cfg.Jitter = 1.5
+
+	if _, err := plugindemo.New(context.Background(), http.NotFoundHandler(), cfg, "demo-plugin"); err == nil {
+		t.Error("expected an error for an invalid jitter")
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2020, time.October, 1, 12, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		desc     string
+		value    string
+		expected time.Duration
+		ok       bool
+	}{
+		{desc: "absent", value: "", ok: false},
+		{desc: "delay seconds", value: "120", expected: 2 * time.Minute, ok: true},
+		{desc: "negative delay seconds", value: "-1", ok: false},
+		{desc: "HTTP date", value: now.Add(90 * time.Second).Format(http.TimeFormat), expected: 90 * time.Second, ok: true},
+		{desc: "past HTTP date", value: now.Add(-time.Minute).Format(http.TimeFormat), expected: 0, ok: true},
+		{desc: "malformed", value: "soon", ok: false},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			header := http.Header{}
+			if test.value != "" {
+				header.Set("Retry-After", test.value)
+			}
+
+			d, ok := plugindemo.RetryAfter(header, now)
+			if ok != test.ok || d != test.expected {
+				t.Errorf("got (%v, %v), want (%v, %v)", d, ok, test.expected, test.ok)
+			}
+		})
+	}
+}
+
+func TestRetryAfterClamp(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 2
+	cfg.BackoffBase = "1m"
+	cfg.MaxRetryAfter = "50ms"
+
+	calls := 0
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		if calls == 1 {
+			rw.Header().Set("Retry-After", "3600")
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+
+	start := time.Now()
+	recorder := serve(t, cfg, next, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+	elapsed := time.Since(start)
+
+	assertStatus(t, recorder, http.StatusOK)
+	if elapsed < 50*time.Millisecond || elapsed > time.Second {
+		t.Errorf("Retry-After was not clamped: %v", elapsed)
+	}
+}
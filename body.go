@@ -0,0 +1,88 @@
+package plugindemo
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+var (
+	errBodyTooLarge = errors.New("request body too large")
+	errSkipRetry    = errors.New("request body too large to retry")
+)
+
+// bufferBody reads the request body when it has to be replayed for the given number of attempts,
+// and returns it along with the number of attempts to make,
+// a single one when the body turned out to be too large to retry.
+func (r *Retry) bufferBody(req *http.Request, attempts int) ([]byte, int, error) {
+	if attempts <= 1 || r.dryRun {
+		return nil, attempts, nil
+	}
+
+	body, err := r.readBody(req)
+	if errors.Is(err, errSkipRetry) {
+		return nil, 1, nil
+	}
+	return body, attempts, err
+}
+
+// readBody reads the request body so that it can be replayed on each attempt.
+// A nil body is returned when the request has none.
+func (r *Retry) readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	if r.skipRetryBodyBytes > 0 && req.ContentLength < 0 {
+		return r.peekBody(req)
+	}
+	defer func() { _ = req.Body.Close() }()
+
+	var reader io.Reader = req.Body
+	if r.maxBodyBytes > 0 {
+		reader = io.LimitReader(req.Body, r.maxBodyBytes+1)
+	}
+
+	body, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	if r.maxBodyBytes > 0 && int64(len(body)) > r.maxBodyBytes {
+		return nil, errBodyTooLarge
+	}
+	return body, nil
+}
+
+// resetBody sets a fresh reader over body as the request body.
+func resetBody(req *http.Request, body []byte) {
+	if body != nil {
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+}
+
+// peekBody reads the body of unknown length of req up to the skip retry threshold.
+// When it is larger, errSkipRetry is returned and the request body is restored for a single attempt.
+func (r *Retry) peekBody(req *http.Request) ([]byte, error) {
+	prefix, err := ioutil.ReadAll(io.LimitReader(req.Body, r.skipRetryBodyBytes+1))
+	if err != nil {
+		_ = req.Body.Close()
+		return nil, err
+	}
+	if int64(len(prefix)) > r.skipRetryBodyBytes {
+		req.Body = peekedBody{Reader: io.MultiReader(bytes.NewReader(prefix), req.Body), Closer: req.Body}
+		return nil, errSkipRetry
+	}
+
+	_ = req.Body.Close()
+	if r.maxBodyBytes > 0 && int64(len(prefix)) > r.maxBodyBytes {
+		return nil, errBodyTooLarge
+	}
+	return prefix, nil
+}
+
+// peekedBody is a request body whose beginning was already read.
+type peekedBody struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,109 @@
+package plugindemo_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/madshargreave/traefik-sleep"
+)
+
+func TestReplayBody(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 3
+
+	var bodies []string
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bodies = append(bodies, string(body))
+		if len(bodies) < 3 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = rw.Write(body)
+	})
+
+	recorder := serve(t, cfg, next, httptest.NewRequest(http.MethodPut, "http://localhost", strings.NewReader("payload")))
+
+	assertStatus(t, recorder, http.StatusOK)
+	if recorder.Body.String() != "payload" {
+		t.Errorf("invalid body: %q", recorder.Body.String())
+	}
+	for i, body := range bodies {
+		if body != "payload" {
+			t.Errorf("attempt %d received body %q", i+1, body)
+		}
+	}
+}
+
+func TestBodyTooLarge(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 2
+	cfg.MaxBodyBytes = 4
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		t.Error("request with a too large body was forwarded")
+	})
+
+	recorder := serve(t, cfg, next, httptest.NewRequest(http.MethodPut, "http://localhost", strings.NewReader("payload")))
+
+	assertStatus(t, recorder, http.StatusRequestEntityTooLarge)
+}
+
+func TestSkipRetryBodyBytes(t *testing.T) {
+	tests := []struct {
+		desc              string
+		body              string
+		unknownLength     bool
+		skipUnknownLength bool
+		expectedAttempts  int
+	}{
+		{desc: "small body", body: "tiny", expectedAttempts: 3},
+		{desc: "large body", body: "much too large", expectedAttempts: 1},
+		{desc: "small body of unknown length", body: "tiny", unknownLength: true, expectedAttempts: 3},
+		{desc: "large body of unknown length", body: "much too large", unknownLength: true, expectedAttempts: 1},
+		{desc: "skipped unknown length", body: "tiny", unknownLength: true, skipUnknownLength: true, expectedAttempts: 1},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			cfg := plugindemo.CreateConfig()
+			cfg.Attempts = 3
+			cfg.SkipRetryBodyBytes = 8
+			cfg.SkipUnknownLength = test.skipUnknownLength
+
+			var bodies []string
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				body, err := ioutil.ReadAll(req.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				bodies = append(bodies, string(body))
+				rw.WriteHeader(http.StatusServiceUnavailable)
+			})
+
+			req := httptest.NewRequest(http.MethodPut, "http://localhost", strings.NewReader(test.body))
+			if test.unknownLength {
+				req.ContentLength = -1
+				req.Body = ioutil.NopCloser(strings.NewReader(test.body))
+			}
+			recorder := serve(t, cfg, next, req)
+
+			assertStatus(t, recorder, http.StatusServiceUnavailable)
+			if len(bodies) != test.expectedAttempts {
+				t.Errorf("invalid number of attempts: %d", len(bodies))
+			}
+			for i, body := range bodies {
+				if body != test.body {
+					t.Errorf("attempt %d received body %q", i+1, body)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package plugindemo
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// retryBudget limits the number of retries made for each client over a sliding window.
+// A nil budget is disabled and allows all retries.
+type retryBudget struct {
+	limit  int
+	window time.Duration
+
+	mu sync.Mutex
+	// retries holds the times of the retries made for each client within the window.
+	retries   map[string][]time.Time
+	lastSweep time.Time
+}
+
+func newRetryBudget(limit int, window time.Duration) (*retryBudget, error) {
+	if limit < 0 {
+		return nil, fmt.Errorf("incorrect value for per IP retry limit (%d)", limit)
+	}
+	if limit == 0 {
+		return nil, nil
+	}
+	if window <= 0 {
+		return nil, errors.New("empty per IP window")
+	}
+
+	return &retryBudget{
+		limit:   limit,
+		window:  window,
+		retries: make(map[string][]time.Time),
+	}, nil
+}
+
+// allow reports whether a retry may be made for client, counting it if so.
+func (b *retryBudget) allow(client string, now time.Time) bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.sweep(now)
+
+	retries := b.prune(b.retries[client], now)
+	if len(retries) >= b.limit {
+		b.retries[client] = retries
+		return false
+	}
+	b.retries[client] = append(retries, now)
+	return true
+}
+
+// refund forgets the last retry counted for client, when it was not made after all.
+func (b *retryBudget) refund(client string) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if retries := b.retries[client]; len(retries) > 0 {
+		b.retries[client] = retries[:len(retries)-1]
+	}
+}
+
+// snapshot returns the number of retries made within the window at now, by client.
+func (b *retryBudget) snapshot(now time.Time) map[string]int {
+	if b == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var counts map[string]int
+	for client, retries := range b.retries {
+		if n := len(b.prune(retries, now)); n > 0 {
+			if counts == nil {
+				counts = make(map[string]int)
+			}
+			counts[client] = n
+		}
+	}
+	return counts
+}
+
+// reset forgets the retries made for client, once its backend recovered.
+func (b *retryBudget) reset(client string) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.retries, client)
+}
+
+// prune drops the retries which are out of the window.
+func (b *retryBudget) prune(retries []time.Time, now time.Time) []time.Time {
+	start := now.Add(-b.window)
+	i := 0
+	for i < len(retries) && !retries[i].After(start) {
+		i++
+	}
+	return retries[i:]
+}
+
+// sweep evicts the clients without any retry in the window, at most once per window,
+// to bound the memory used by clients which went away.
+func (b *retryBudget) sweep(now time.Time) {
+	if now.Sub(b.lastSweep) < b.window {
+		return
+	}
+	b.lastSweep = now
+
+	for client, retries := range b.retries {
+		if len(b.prune(retries, now)) == 0 {
+			delete(b.retries, client)
+		}
+	}
+}
+
+// clientIP returns the IP of the client that sent req:
+// the first hop of the X-Forwarded-For header if any, or the remote address.
+func clientIP(req *http.Request) string {
+	if forwarded := req.Header.Get("X-Forwarded-For"); forwarded != "" {
+		return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+	}
+
+	return remoteIP(req)
+}
+
+// remoteIP returns the IP of the remote address of req.
+func remoteIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
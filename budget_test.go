@@ -0,0 +1,183 @@
+package plugindemo_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/madshargreave/traefik-sleep"
+)
+
+func TestPerIPRetryLimit(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 3
+	cfg.PerIPRetryLimit = 3
+	cfg.PerIPWindow = "1m"
+
+	calls := map[string]int{}
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		client := strings.Split(req.Header.Get("X-Forwarded-For"), ",")[0]
+		calls[client]++
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	handler, err := plugindemo.New(context.Background(), next, cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := plugindemo.NewFakeClock(time.Now())
+	handler.(*plugindemo.Retry).SetClock(clock)
+
+	request := func(ip string) {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+		req.Header.Set("X-Forwarded-For", ip)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// The first request spends 2 retries, the second one the last retry of the budget.
+	request("10.0.0.1")
+	request("10.0.0.1")
+	if calls["10.0.0.1"] != 5 {
+		t.Fatalf("invalid number of attempts within the budget: %d", calls["10.0.0.1"])
+	}
+
+	request("10.0.0.1")
+	if calls["10.0.0.1"] != 6 {
+		t.Errorf("request retried past the budget: %d attempts", calls["10.0.0.1"])
+	}
+
+	request("10.0.0.2")
+	if calls["10.0.0.2"] != 3 {
+		t.Errorf("other client affected by the budget: %d attempts", calls["10.0.0.2"])
+	}
+
+	clock.Advance(time.Minute)
+	request("10.0.0.1")
+	if calls["10.0.0.1"] != 9 {
+		t.Errorf("budget not replenished after the window: %d attempts", calls["10.0.0.1"])
+	}
+}
+
+func TestPerIPRetryLimitExhausted(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 5
+	cfg.PerIPRetryLimit = 1
+	cfg.PerIPWindow = "1m"
+	cfg.RetriesExhaustedStatus = http.StatusBadGateway
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	handler, err := plugindemo.New(context.Background(), next, cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+	// The request refused a retry by the budget failed as if it had used all its attempts.
+	assertStatus(t, recorder, http.StatusBadGateway)
+	expected := plugindemo.Metrics{Requests: 1, Retries: 1, RetriesExhausted: 1}
+	if got := handler.(*plugindemo.Retry).Metrics(); got != expected {
+		t.Errorf("invalid metrics: got %+v, want %+v", got, expected)
+	}
+}
+
+func TestPerIPRetryLimitRefused(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 3
+	cfg.PerIPRetryLimit = 10
+	cfg.PerIPWindow = "1m"
+	cfg.SharedAttempts = 1
+
+	calls := 0
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	handler, err := plugindemo.New(context.Background(), next, cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	retry := handler.(*plugindemo.Retry)
+
+	for i := 0; i < 3; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+	}
+
+	// The shared attempts refuse every retry: none is counted in the budget.
+	if calls != 3 {
+		t.Errorf("invalid number of attempts: %d", calls)
+	}
+	if budgets := retry.Status().RetryBudgets; len(budgets) != 0 {
+		t.Errorf("retries refused by the shared attempts counted in the budget: %v", budgets)
+	}
+}
+
+func TestPerIPRetryLimitValidation(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 1
+	cfg.PerIPRetryLimit = 3
+
+	if _, err := plugindemo.New(context.Background(), http.NotFoundHandler(), cfg, "demo-plugin"); err == nil {
+		t.Error("expected an error for a missing per IP window")
+	}
+}
+
+func TestPerIPRetryLimitResetOnSuccess(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 3
+	cfg.BackoffBase = "100ms"
+	cfg.PerIPRetryLimit = 2
+	cfg.PerIPWindow = "1m"
+
+	calls := 0
+	healthy := false
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		if !healthy {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+
+	handler, err := plugindemo.New(context.Background(), next, cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := plugindemo.NewFakeClock(time.Now())
+	handler.(*plugindemo.Retry).SetClock(clock)
+
+	request := func() {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+		req.Header.Set("X-Forwarded-For", "10.0.0.1")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// The failing request spends the whole budget.
+	request()
+	request()
+	if calls != 4 {
+		t.Fatalf("invalid number of attempts while failing: %d", calls)
+	}
+
+	healthy = true
+	request()
+	healthy = false
+
+	calls = 0
+	sleeps := len(clock.Sleeps())
+	request()
+	if calls != 3 {
+		t.Errorf("budget not reset after a success: %d attempts", calls)
+	}
+	expected := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond}
+	if got := clock.Sleeps()[sleeps:]; !reflect.DeepEqual(got, expected) {
+		t.Errorf("backoff not restarted from the base: %v", got)
+	}
+}
@@ -0,0 +1,150 @@
+package plugindemo
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// CircuitState is the state of the circuit breaker.
+type CircuitState string
+
+// Circuit breaker states.
+const (
+	// CircuitClosed lets all requests through.
+	CircuitClosed CircuitState = "closed"
+	// CircuitOpen rejects all requests until the open duration elapsed.
+	CircuitOpen CircuitState = "open"
+	// CircuitHalfOpen lets a single trial request through to decide whether to close the circuit again.
+	CircuitHalfOpen CircuitState = "half-open"
+)
+
+// circuit is a circuit breaker tracking the failure ratio over the last requests.
+// A nil circuit is disabled and lets all requests through.
+type circuit struct {
+	threshold    float64
+	openDuration time.Duration
+
+	mu       sync.Mutex
+	state    CircuitState
+	outcomes []bool
+	next     int
+	count    int
+	failures int
+	openedAt time.Time
+	trial    bool
+}
+
+func newCircuit(windowSize int, threshold float64, openDuration time.Duration) (*circuit, error) {
+	if windowSize < 0 {
+		return nil, fmt.Errorf("incorrect value for window size (%d)", windowSize)
+	}
+	if windowSize == 0 {
+		return nil, nil
+	}
+	if threshold <= 0 || threshold > 1 {
+		return nil, fmt.Errorf("incorrect value for failure threshold (%v)", threshold)
+	}
+
+	return &circuit{
+		threshold:    threshold,
+		openDuration: openDuration,
+		state:        CircuitClosed,
+		outcomes:     make([]bool, windowSize),
+	}, nil
+}
+
+// allow reports whether a request may be forwarded.
+// Every allowed request must be followed by a call to record.
+func (c *circuit) allow(now time.Time) bool {
+	if c == nil {
+		return true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state == CircuitOpen && now.Sub(c.openedAt) >= c.openDuration {
+		c.state = CircuitHalfOpen
+	}
+
+	switch c.state {
+	case CircuitOpen:
+		return false
+	case CircuitHalfOpen:
+		if c.trial {
+			return false
+		}
+		c.trial = true
+		return true
+	default:
+		return true
+	}
+}
+
+// record adds the outcome of an allowed request.
+func (c *circuit) record(failed bool, now time.Time) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state == CircuitHalfOpen {
+		c.trial = false
+		if failed {
+			c.open(now)
+		} else {
+			c.reset()
+		}
+		return
+	}
+
+	if c.count == len(c.outcomes) {
+		if c.outcomes[c.next] {
+			c.failures--
+		}
+	} else {
+		c.count++
+	}
+	c.outcomes[c.next] = failed
+	if failed {
+		c.failures++
+	}
+	c.next = (c.next + 1) % len(c.outcomes)
+
+	if c.count == len(c.outcomes) && float64(c.failures)/float64(c.count) > c.threshold {
+		c.open(now)
+	}
+}
+
+func (c *circuit) open(now time.Time) {
+	c.state = CircuitOpen
+	c.openedAt = now
+}
+
+func (c *circuit) reset() {
+	c.state = CircuitClosed
+	c.next, c.count, c.failures = 0, 0, 0
+}
+
+func (c *circuit) currentState(now time.Time) CircuitState {
+	if c == nil {
+		return CircuitClosed
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state == CircuitOpen && now.Sub(c.openedAt) >= c.openDuration {
+		return CircuitHalfOpen
+	}
+	return c.state
+}
+
+// CircuitState returns the current state of the circuit breaker,
+// always closed when the circuit breaker is disabled.
+func (r *Retry) CircuitState() CircuitState {
+	return r.circuit.currentState(r.clock.Now())
+}
@@ -0,0 +1,200 @@
+package plugindemo_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/madshargreave/traefik-sleep"
+)
+
+func TestCircuit(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 1
+	cfg.WindowSize = 4
+	cfg.FailureThreshold = 0.5
+	cfg.OpenDuration = "50ms"
+
+	calls := 0
+	status := http.StatusServiceUnavailable
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		rw.WriteHeader(status)
+	})
+
+	handler, err := plugindemo.New(context.Background(), next, cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	retry := handler.(*plugindemo.Retry)
+	clock := plugindemo.NewFakeClock(time.Now())
+	retry.SetClock(clock)
+
+	request := func() *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+		return recorder
+	}
+
+	for i := 0; i < 4; i++ {
+		if state := retry.CircuitState(); state != plugindemo.CircuitClosed {
+			t.Fatalf("circuit %s after %d failures", state, i)
+		}
+		request()
+	}
+
+	if state := retry.CircuitState(); state != plugindemo.CircuitOpen {
+		t.Fatalf("circuit %s after the failure threshold was exceeded", state)
+	}
+	assertStatus(t, request(), http.StatusServiceUnavailable)
+	if calls != 4 {
+		t.Errorf("request forwarded while the circuit is open: %d calls", calls)
+	}
+
+	clock.Advance(50 * time.Millisecond)
+	if state := retry.CircuitState(); state != plugindemo.CircuitHalfOpen {
+		t.Fatalf("circuit %s after the open duration", state)
+	}
+
+	status = http.StatusOK
+	assertStatus(t, request(), http.StatusOK)
+	if state := retry.CircuitState(); state != plugindemo.CircuitClosed {
+		t.Errorf("circuit %s after a successful trial request", state)
+	}
+	if calls != 5 {
+		t.Errorf("invalid number of forwarded requests: %d", calls)
+	}
+}
+
+func TestCircuitHalfOpenFailure(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 1
+	cfg.WindowSize = 1
+	cfg.FailureThreshold = 0.5
+	cfg.OpenDuration = "20ms"
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	handler, err := plugindemo.New(context.Background(), next, cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	retry := handler.(*plugindemo.Retry)
+	clock := plugindemo.NewFakeClock(time.Now())
+	retry.SetClock(clock)
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+	clock.Advance(20 * time.Millisecond)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+	if state := retry.CircuitState(); state != plugindemo.CircuitOpen {
+		t.Errorf("circuit %s after a failed trial request", state)
+	}
+}
+
+func TestCircuitHalfOpenAbort(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 1
+	cfg.WindowSize = 1
+	cfg.FailureThreshold = 0.5
+	cfg.OpenDuration = "20ms"
+
+	abort := false
+	calls := 0
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		if abort {
+			panic(http.ErrAbortHandler)
+		}
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	handler, err := plugindemo.New(context.Background(), next, cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	retry := handler.(*plugindemo.Retry)
+	clock := plugindemo.NewFakeClock(time.Now())
+	retry.SetClock(clock)
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+	clock.Advance(20 * time.Millisecond)
+
+	abort = true
+	func() {
+		defer func() {
+			if recovered := recover(); recovered != http.ErrAbortHandler {
+				t.Errorf("invalid panic: %v", recovered)
+			}
+		}()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+	}()
+
+	// The aborted trial request counts as a failure, the next trial being let through once the circuit is half-open again.
+	if state := retry.CircuitState(); state != plugindemo.CircuitOpen {
+		t.Errorf("circuit %s after an aborted trial request", state)
+	}
+	abort = false
+	clock.Advance(20 * time.Millisecond)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+	if calls != 3 {
+		t.Errorf("trial request not forwarded after an aborted one: %d calls", calls)
+	}
+}
+
+func TestCircuitOpenReleasesMemory(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 2
+	cfg.WindowSize = 1
+	cfg.FailureThreshold = 0.5
+	cfg.OpenDuration = "1m"
+	cfg.MaxTotalBufferBytes = 100
+
+	calls := 0
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	handler, err := plugindemo.New(context.Background(), next, cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	retry := handler.(*plugindemo.Retry)
+	clock := plugindemo.NewFakeClock(time.Now())
+	retry.SetClock(clock)
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+	if state := retry.CircuitState(); state != plugindemo.CircuitOpen {
+		t.Fatalf("circuit %s after a failure", state)
+	}
+
+	// The bodies of the requests rejected by the open circuit are not kept reserved.
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodPut, "http://localhost", strings.NewReader(strings.Repeat("a", 50)))
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	clock.Advance(time.Minute)
+	calls = 0
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+	if calls != 2 {
+		t.Errorf("trial request not retried: %d calls", calls)
+	}
+}
+
+func TestInvalidCircuit(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 1
+	cfg.WindowSize = 10
+	cfg.FailureThreshold = 1.5
+
+	if _, err := plugindemo.New(context.Background(), http.NotFoundHandler(), cfg, "demo-plugin"); err == nil {
+		t.Error("expected an error for an invalid failure threshold")
+	}
+}
@@ -0,0 +1,39 @@
+package plugindemo
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+)
+
+// NewWithClient creates a new Demo plugin sending its own requests, to the health check and fallback URLs,
+// with client, or with a default client with sane timeouts when nil.
+func NewWithClient(ctx context.Context, next http.Handler, config *Config, name string, client *http.Client) (http.Handler, error) {
+	r, err := newRetry(ctx, next, config, name, realClock{}, client, nil)
+	if err != nil {
+		return nil, err
+	}
+	r.start()
+	return r, nil
+}
+
+// newClient returns the default client of the requests sent by the plugin itself.
+// It has no overall timeout, the health checks being bounded by their context
+// and the fallback responses being streamed.
+func newClient() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   10 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: 30 * time.Second,
+			ExpectContinueTimeout: time.Second,
+		},
+	}
+}
@@ -0,0 +1,58 @@
+package plugindemo_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/madshargreave/traefik-sleep"
+)
+
+// recordingTransport records the paths of the requests it sends through http.DefaultTransport.
+type recordingTransport struct {
+	mu    sync.Mutex
+	paths []string
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	t.paths = append(t.paths, req.URL.Path)
+	t.mu.Unlock()
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestNewWithClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte(req.URL.Path))
+	}))
+	defer server.Close()
+
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 2
+	cfg.HealthCheckURL = server.URL + "/health"
+	cfg.FallbackURL = server.URL + "/fallback"
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	transport := &recordingTransport{}
+	handler, err := plugindemo.NewWithClient(context.Background(), next, cfg, "demo-plugin", &http.Client{Transport: transport})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://localhost/items", nil))
+
+	assertStatus(t, recorder, http.StatusOK)
+	if body := recorder.Body.String(); body != "/fallback/items" {
+		t.Errorf("invalid fallback response: %q", body)
+	}
+	expected := []string{"/health", "/fallback/items"}
+	if len(transport.paths) != len(expected) || transport.paths[0] != expected[0] || transport.paths[1] != expected[1] {
+		t.Errorf("invalid outbound requests: got %v, want %v", transport.paths, expected)
+	}
+}
@@ -0,0 +1,31 @@
+package plugindemo
+
+import (
+	"context"
+	"time"
+)
+
+// clock provides the time to the plugin, so that tests can control it.
+type clock interface {
+	Now() time.Time
+	// Sleep waits for d, or until ctx is done in which case the context error is returned.
+	Sleep(ctx context.Context, d time.Duration) error
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,188 @@
+package plugindemo
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// varyHeaders are the request headers the response may vary with, part of the key of the requests.
+var varyHeaders = []string{"Accept", "Accept-Encoding", "Accept-Language", "Authorization", "Cookie"}
+
+// collapseGroup tracks the collapsed requests in flight, per key.
+type collapseGroup struct {
+	mu    sync.Mutex
+	calls map[string]*collapsedCall
+}
+
+// collapsedCall is a request in flight whose response is shared with the identical requests received meanwhile.
+type collapsedCall struct {
+	done chan struct{}
+	// sw is the copy of the response, set before done is closed, nil when it cannot be shared.
+	sw *statusWriter
+}
+
+// join returns the call in flight for key, or a new one along with true when the caller is to make it.
+func (g *collapseGroup) join(key string) (*collapsedCall, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.calls == nil {
+		g.calls = make(map[string]*collapsedCall)
+	}
+	if call, ok := g.calls[key]; ok {
+		return call, false
+	}
+	call := &collapsedCall{done: make(chan struct{})}
+	g.calls[key] = call
+	return call, true
+}
+
+// finish shares sw with the callers waiting for call.
+func (g *collapseGroup) finish(key string, call *collapsedCall, sw *statusWriter) {
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+
+	call.sw = sw
+	close(call.done)
+}
+
+// collapses reports whether req may share the response of an identical request in flight:
+// it is a GET without body, and collapsing is enabled.
+func (r *Retry) collapses(req *http.Request) bool {
+	return r.collapseRequests && req.Method == http.MethodGet && req.ContentLength == 0 && len(req.TransferEncoding) == 0
+}
+
+// requestKey returns the key of the requests identical to req.
+func requestKey(req *http.Request) string {
+	var b strings.Builder
+	b.WriteString(req.Host)
+	b.WriteString(req.URL.RequestURI())
+	for _, key := range varyHeaders {
+		b.WriteString("\n")
+		b.WriteString(strings.Join(req.Header.Values(key), ","))
+	}
+	return b.String()
+}
+
+// serveCollapsed serves req, sharing the response of an identical request in flight if any.
+// The first request is served as usual, its response being copied as it is sent to its client.
+// The requests received meanwhile wait for it, and are served on their own
+// when the response cannot be shared, or was streamed or too large to be copied.
+func (r *Retry) serveCollapsed(rw http.ResponseWriter, req *http.Request) {
+	key := requestKey(req)
+	call, leader := r.collapsed.join(key)
+	if leader {
+		cw := r.newCollapsedWriter(rw)
+		defer cw.copy.memory.release()
+		r.serveRetried(cw, req)
+		if !cw.copy.wroteHeader {
+			cw.WriteHeader(http.StatusOK)
+		}
+		r.collapsed.finish(key, call, cw.shared())
+		return
+	}
+
+	select {
+	case <-call.done:
+	case <-req.Context().Done():
+		r.interrupted(req, req.Context()).flush(rw)
+		return
+	}
+	if call.sw == nil || !shareable(call.sw) {
+		r.serveRetried(rw, req)
+		return
+	}
+	copyHeader(rw.Header(), call.sw.header.Clone())
+	rw.WriteHeader(call.sw.status)
+	_, _ = rw.Write(call.sw.body.Bytes())
+}
+
+// collapsedWriter passes the response of the first of the collapsed requests through to its client,
+// keeping a copy of it for the requests waiting for it within the max response and total buffer bytes.
+// A streamed response is not copied, the waiting requests being served on their own.
+type collapsedWriter struct {
+	http.ResponseWriter
+	copy *statusWriter
+	// dropped is set once the response turned out to be streamed or too large to be copied.
+	dropped bool
+	// streamingContentTypes are the content types of the streamed responses.
+	streamingContentTypes []string
+}
+
+func (r *Retry) newCollapsedWriter(rw http.ResponseWriter) *collapsedWriter {
+	sw := newStatusWriter()
+	sw.maxBufferBytes = r.maxResponseBufferBytes
+	sw.memory = r.memory.reservation()
+	return &collapsedWriter{ResponseWriter: rw, copy: sw, streamingContentTypes: r.streamingContentTypes}
+}
+
+func (w *collapsedWriter) WriteHeader(status int) {
+	if !w.copy.wroteHeader && status >= http.StatusOK {
+		copyHeader(w.copy.header, w.Header().Clone())
+		w.copy.status = status
+		w.copy.wroteHeader = true
+		if hasContentType(w.copy.header, w.streamingContentTypes) {
+			w.drop()
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *collapsedWriter) Write(b []byte) (int, error) {
+	if !w.copy.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.dropped {
+		if w.copy.canBuffer(len(b)) {
+			n, _ := w.copy.body.Write(b)
+			w.copy.buffered += int64(n)
+		} else {
+			w.drop()
+		}
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush sends the response written so far to the client, a flushed response being streamed.
+func (w *collapsedWriter) Flush() {
+	w.drop()
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *collapsedWriter) drop() {
+	if !w.dropped {
+		w.dropped = true
+		w.copy.resetBody()
+		w.copy.releaseMemory()
+	}
+}
+
+// shared returns the copy of the response, or nil when it was dropped.
+func (w *collapsedWriter) shared() *statusWriter {
+	if w.dropped {
+		return nil
+	}
+	return w.copy
+}
+
+// shareable reports whether the response can be sent to the other clients:
+// it has a status cacheable by default, and is neither private nor setting cookies.
+func shareable(sw *statusWriter) bool {
+	switch sw.status {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusNoContent,
+		http.StatusMultipleChoices, http.StatusMovedPermanently, http.StatusPermanentRedirect,
+		http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusGone,
+		http.StatusRequestURITooLong, http.StatusNotImplemented:
+	default:
+		return false
+	}
+	if sw.hijacked || len(sw.header.Values("Set-Cookie")) > 0 {
+		return false
+	}
+	cacheControl := strings.ToLower(strings.Join(sw.header.Values("Cache-Control"), ","))
+	return !strings.Contains(cacheControl, "no-store") && !strings.Contains(cacheControl, "private")
+}
@@ -0,0 +1,182 @@
+package plugindemo_test
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/madshargreave/traefik-sleep"
+)
+
+func TestCollapseRequests(t *testing.T) {
+	tests := []struct {
+		desc      string
+		setCookie bool
+		expected  int32
+	}{
+		{desc: "shared", expected: 1},
+		{desc: "not cacheable", setCookie: true, expected: 10},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			cfg := plugindemo.CreateConfig()
+			cfg.Attempts = 2
+			cfg.CollapseRequests = true
+
+			var calls int32
+			handler, err := plugindemo.New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				atomic.AddInt32(&calls, 1)
+				time.Sleep(100 * time.Millisecond)
+				if test.setCookie {
+					rw.Header().Set("Set-Cookie", "session=1")
+				}
+				_, _ = rw.Write([]byte("ok"))
+			}), cfg, "demo-plugin")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			const requests = 10
+			var wg sync.WaitGroup
+			for i := 0; i < requests; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					recorder := httptest.NewRecorder()
+					handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://localhost/items", nil))
+					assertStatus(t, recorder, http.StatusOK)
+					if body := recorder.Body.String(); body != "ok" {
+						t.Errorf("invalid body: %q", body)
+					}
+				}()
+			}
+			wg.Wait()
+
+			if c := atomic.LoadInt32(&calls); c != test.expected {
+				t.Errorf("invalid number of backend calls: %d", c)
+			}
+		})
+	}
+}
+
+func TestCollapseRequestsDistinct(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 2
+	cfg.CollapseRequests = true
+
+	var calls int32
+	handler, err := plugindemo.New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(100 * time.Millisecond)
+		_, _ = rw.Write([]byte(req.Header.Get("Accept")))
+	}), cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for _, accept := range []string{"text/html", "application/json"} {
+		accept := accept
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/items", nil)
+			req.Header.Set("Accept", accept)
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+			if body := recorder.Body.String(); body != accept {
+				t.Errorf("invalid body for %s: %q", accept, body)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if c := atomic.LoadInt32(&calls); c != 2 {
+		t.Errorf("invalid number of backend calls: %d", c)
+	}
+}
+
+func TestCollapseRequestsStreaming(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 2
+	cfg.CollapseRequests = true
+	cfg.StreamingContentTypes = []string{"text/event-stream"}
+
+	release := make(chan struct{})
+	handler, err := plugindemo.New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "text/event-stream")
+		_, _ = rw.Write([]byte("data: 1\n\n"))
+		rw.(http.Flusher).Flush()
+		<-release
+	}), cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	defer close(release)
+
+	// The event is received while the handler is still streaming.
+	received := make(chan string, 1)
+	go func() {
+		resp, err := http.Get(server.URL + "/events")
+		if err != nil {
+			received <- err.Error()
+			return
+		}
+		defer func() { _ = resp.Body.Close() }()
+		line, _ := bufio.NewReader(resp.Body).ReadString('\n')
+		received <- line
+	}()
+	select {
+	case line := <-received:
+		if line != "data: 1\n" {
+			t.Errorf("invalid event: %q", line)
+		}
+	case <-time.After(time.Second):
+		t.Error("streamed response buffered")
+	}
+}
+
+func TestCollapseRequestsMaxResponseBufferBytes(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 2
+	cfg.CollapseRequests = true
+	cfg.MaxResponseBufferBytes = 4
+
+	var calls int32
+	handler, err := plugindemo.New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(100 * time.Millisecond)
+		_, _ = rw.Write([]byte("too large"))
+	}), cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://localhost/items", nil))
+			if body := recorder.Body.String(); body != "too large" {
+				t.Errorf("invalid body: %q", body)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// The response is too large to be copied: the waiting request is served on its own.
+	if c := atomic.LoadInt32(&calls); c != 2 {
+		t.Errorf("invalid number of backend calls: %d", c)
+	}
+}
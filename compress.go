@@ -0,0 +1,103 @@
+package plugindemo
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+)
+
+// buffer appends b to the buffered body, which is gzip compressed
+// once it is larger than the compress threshold.
+func (w *statusWriter) buffer(b []byte) (int, error) {
+	if w.gz != nil {
+		n, err := w.gz.Write(b)
+		w.buffered += int64(n)
+		return n, err
+	}
+
+	n, err := w.body.Write(b)
+	w.buffered += int64(n)
+	if w.compressThreshold > 0 && w.buffered > w.compressThreshold && w.header.Get("Content-Encoding") == "" {
+		w.compress()
+	}
+	return n, err
+}
+
+// compress moves the buffered body to the compressed buffer, the following writes being compressed too.
+// A response already encoded by the backend is left as is, it would not get any smaller.
+func (w *statusWriter) compress() {
+	w.gz, _ = gzip.NewWriterLevel(&w.compressed, gzip.BestSpeed)
+	_, _ = w.gz.Write(w.body.Bytes())
+	w.body = bytes.Buffer{}
+}
+
+// bodyBytes returns the buffered body, decompressing it first if it is compressed.
+func (w *statusWriter) bodyBytes() []byte {
+	if w.gz != nil {
+		_ = w.gz.Close()
+		w.gz = nil
+		zr, err := gzip.NewReader(&w.compressed)
+		if err == nil {
+			_, _ = io.Copy(&w.body, zr)
+		}
+		w.compressed = bytes.Buffer{}
+	}
+	return w.body.Bytes()
+}
+
+// bodyPrefix returns the first limit bytes of the buffered body, or all of it when limit is zero,
+// without decompressing the rest of a compressed body.
+func (w *statusWriter) bodyPrefix(limit int64) []byte {
+	if w.gz == nil {
+		body := w.body.Bytes()
+		if limit > 0 && int64(len(body)) > limit {
+			body = body[:limit]
+		}
+		return body
+	}
+
+	// Flushing makes the data written so far readable, the stream being only closed once the body is complete.
+	_ = w.gz.Flush()
+	zr, err := gzip.NewReader(bytes.NewReader(w.compressed.Bytes()))
+	if err != nil {
+		return nil
+	}
+	var r io.Reader = zr
+	if limit > 0 {
+		r = io.LimitReader(zr, limit)
+	}
+	body, _ := ioutil.ReadAll(r)
+	return body
+}
+
+// writeBodyTo writes the buffered body to dst, decompressing it as it goes if it is compressed.
+func (w *statusWriter) writeBodyTo(dst io.Writer) (int, error) {
+	if w.gz == nil {
+		return dst.Write(w.body.Bytes())
+	}
+
+	_ = w.gz.Close()
+	zr, err := gzip.NewReader(&w.compressed)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(dst, zr)
+	return int(n), err
+}
+
+// resetBody drops the buffered body.
+func (w *statusWriter) resetBody() {
+	w.body.Reset()
+	w.compressed = bytes.Buffer{}
+	w.gz = nil
+	w.buffered = 0
+}
+
+// compressThreshold returns the buffered size above which the attempt responses are compressed, never when zero.
+func compressThreshold(config *Config) int64 {
+	if !config.CompressBuffer {
+		return 0
+	}
+	return config.CompressBufferThreshold
+}
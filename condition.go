@@ -0,0 +1,314 @@
+package plugindemo
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// parseStatusCodes validates the configured retry statuses and returns them as a set,
+// or nil when they are not configured. An empty, but configured, list gives an empty set.
+func parseStatusCodes(codes []int) (map[int]bool, error) {
+	if codes == nil {
+		return nil, nil
+	}
+
+	set := make(map[int]bool, len(codes))
+	for _, code := range codes {
+		if code < 100 || code > 599 {
+			return nil, fmt.Errorf("incorrect value for retry status code (%d)", code)
+		}
+		set[code] = true
+	}
+	return set, nil
+}
+
+// statusRange is an inclusive range of statuses.
+type statusRange struct {
+	low, high int
+}
+
+type statusRanges []statusRange
+
+func (s statusRanges) contains(status int) bool {
+	for _, sr := range s {
+		if status >= sr.low && status <= sr.high {
+			return true
+		}
+	}
+	return false
+}
+
+// parseStatusRanges parses comma separated statuses and ranges of statuses such as "429,500-504",
+// returning nil when none are configured.
+func parseStatusRanges(values []string) (statusRanges, error) {
+	var ranges statusRanges
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			sr, err := parseStatusRange(strings.TrimSpace(part))
+			if err != nil {
+				return nil, fmt.Errorf("incorrect value for retry status range (%s): %w", value, err)
+			}
+			ranges = append(ranges, sr)
+		}
+	}
+	return ranges, nil
+}
+
+func parseStatusRange(value string) (statusRange, error) {
+	low, high := value, value
+	if i := strings.Index(value, "-"); i >= 0 {
+		low, high = value[:i], value[i+1:]
+	}
+
+	var sr statusRange
+	var err error
+	if sr.low, err = parseStatus(low); err != nil {
+		return statusRange{}, err
+	}
+	if sr.high, err = parseStatus(high); err != nil {
+		return statusRange{}, err
+	}
+	if sr.low > sr.high {
+		return statusRange{}, fmt.Errorf("empty range %d-%d", sr.low, sr.high)
+	}
+	return sr, nil
+}
+
+func parseStatus(value string) (int, error) {
+	status, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	if status < 100 || status > 599 {
+		return 0, fmt.Errorf("status %d out of range", status)
+	}
+	return status, nil
+}
+
+// hasRetryCondition reports whether config sets any of the conditions to retry on,
+// an empty list of retry status codes, or disabling the retries by status, counting as one.
+func hasRetryCondition(config *Config) bool {
+	return config.RetryStatusCodes != nil ||
+		len(config.RetryStatusRanges) > 0 ||
+		len(config.StatusAttempts) > 0 ||
+		len(config.RetryOnHeader) > 0 ||
+		len(config.RetryOnBodyContains) > 0 ||
+		config.RetryOnEmptyBody ||
+		config.RetryOnError ||
+		config.RetryOnErrorOnly ||
+		config.RetryOnPanic ||
+		config.GRPCMode
+}
+
+// grpcUnavailable is the gRPC code of an unavailable service.
+const grpcUnavailable = 14
+
+// parseGRPCCodes returns the given gRPC codes as a set.
+func parseGRPCCodes(codes []int) map[int]bool {
+	set := make(map[int]bool, len(codes))
+	for _, code := range codes {
+		set[code] = true
+	}
+	return set
+}
+
+// parseMethods returns the given methods as a set, or nil when there are none.
+func parseMethods(methods []string) map[string]bool {
+	if len(methods) == 0 {
+		return nil
+	}
+
+	set := make(map[string]bool, len(methods))
+	for _, method := range methods {
+		set[strings.ToUpper(method)] = true
+	}
+	return set
+}
+
+func validatePathPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("incorrect value for exclude path (%s): %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// bypasses reports whether req is forwarded as is, without any delay, retry or logging,
+// because its method is not included or its path is excluded.
+func (r *Retry) bypasses(req *http.Request) bool {
+	if r.includeMethods != nil && !r.includeMethods[req.Method] {
+		return true
+	}
+	for _, pattern := range r.excludePaths {
+		if matched, _ := path.Match(pattern, req.URL.Path); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// attemptsFor returns the maximum number of attempts for req under p.
+func (r *Retry) attemptsFor(req *http.Request, p *policy) int {
+	if r.retryIdempotentOnly && !isIdempotent(req.Method) && !r.isGRPC(req) {
+		return 1
+	}
+	if r.retryOnlyIfHeader != "" && req.Header.Get(r.retryOnlyIfHeader) == "" {
+		return 1
+	}
+	if r.skipRetryBodyBytes > 0 {
+		if req.ContentLength > r.skipRetryBodyBytes || req.ContentLength < 0 && r.skipUnknownLength {
+			return 1
+		}
+	}
+	if r.maxStatusAttempts > p.attempts {
+		return r.maxStatusAttempts
+	}
+	return p.attempts
+}
+
+// parseStatusAttempts validates the attempts allowed per status and returns their maximum.
+func parseStatusAttempts(statusAttempts map[int]int, maxAttempts int) (int, error) {
+	max := 0
+	for status, attempts := range statusAttempts {
+		if status < 100 || status > 599 {
+			return 0, fmt.Errorf("incorrect value for status attempts status (%d)", status)
+		}
+		if attempts <= 0 {
+			return 0, fmt.Errorf("incorrect value for status %d attempts (%d)", status, attempts)
+		}
+		if err := validateMaxAttempts(attempts, maxAttempts); err != nil {
+			return 0, fmt.Errorf("status %d: %w", status, err)
+		}
+		if attempts > max {
+			max = attempts
+		}
+	}
+	return max, nil
+}
+
+// statusExhausted counts an attempt failing with status in counts,
+// and reports whether it was the last one allowed for that status:
+// the status attempts if listed, or the attempts of p otherwise.
+func (r *Retry) statusExhausted(counts map[int]int, p *policy, status int) bool {
+	if counts == nil {
+		return false
+	}
+	counts[status]++
+	limit, ok := r.statusAttempts[status]
+	if !ok {
+		limit = p.attempts
+	}
+	return counts[status] >= limit
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+// AttemptResponse is the response of an attempt, as given to a RetryDecider.
+type AttemptResponse interface {
+	StatusCode() int
+	Header() http.Header
+}
+
+// RetryDecider reports whether resp, the response of an attempt of req, should be retried
+// with the given attempt, 2 for the first retry.
+type RetryDecider func(resp AttemptResponse, req *http.Request, attempt int) bool
+
+// shouldRetry reports whether the response of an attempt of req made under p should be retried
+// with the given attempt, using the retry decider when set.
+func (r *Retry) shouldRetry(p *policy, sw *statusWriter, req *http.Request, attempt int) bool {
+	if r.decider != nil {
+		return r.decider(sw, req, attempt)
+	}
+	return r.failed(p, sw)
+}
+
+// chance reports whether a failed attempt is retried, according to the retry probability, if any.
+func (r *Retry) chance() bool {
+	return r.retryProbability == 0 || r.retryProbability >= 1 || randomFloat64() < r.retryProbability
+}
+
+// failed reports whether the response of an attempt made under p failed,
+// because of a connection error or of a retryable status or header, as enabled.
+// The status of a panic is not one of the backend: panics are only retried when enabled.
+func (r *Retry) failed(p *policy, sw *statusWriter) bool {
+	if r.retryOnError && sw.connectionError() {
+		return true
+	}
+	if sw.panicked {
+		return r.retryOnPanic
+	}
+	if r.grpcMode && r.hasRetryGRPCStatus(sw.Header()) {
+		return true
+	}
+	return r.retryOnStatus && (r.hasRetryableStatus(p, sw) || r.hasRetryHeader(sw.Header()) || r.hasRetryBody(sw) || r.hasEmptyBody(sw))
+}
+
+// hasEmptyBody reports whether sw is a 200 response without a body, when enabled.
+// The other statuses, such as 204, are legitimately empty.
+func (r *Retry) hasEmptyBody(sw *statusWriter) bool {
+	return r.retryOnEmptyBody && !sw.committed && !sw.noResponse && !sw.head &&
+		sw.StatusCode() == http.StatusOK && sw.length == 0
+}
+
+// hasRetryableStatus reports whether sw has a retryable status and, when retry content types are configured,
+// a matching content type or no response from the backend at all.
+func (r *Retry) hasRetryableStatus(p *policy, sw *statusWriter) bool {
+	if !p.isRetryable(sw.status) {
+		return false
+	}
+	return len(r.retryContentTypes) == 0 || sw.noResponse || hasContentType(sw.Header(), r.retryContentTypes)
+}
+
+// hasRetryBody reports whether the start of the buffered body of sw, up to the max inspect bytes,
+// contains any of the configured substrings. The body is inspected as written, compressed or not.
+func (r *Retry) hasRetryBody(sw *statusWriter) bool {
+	if len(r.retryOnBodyContains) == 0 || sw.committed {
+		return false
+	}
+	body := sw.bodyPrefix(r.maxInspectBytes)
+	for _, s := range r.retryOnBodyContains {
+		if bytes.Contains(body, []byte(s)) {
+			return true
+		}
+	}
+	return false
+}
+
+// isGRPC reports whether req is a gRPC request handled in gRPC mode,
+// whose method is always POST, and which is retried on the gRPC codes meaning that it can be.
+func (r *Retry) isGRPC(req *http.Request) bool {
+	return r.grpcMode && strings.HasPrefix(req.Header.Get("Content-Type"), "application/grpc")
+}
+
+// hasRetryGRPCStatus reports whether the grpc-status of a response, usually a trailer,
+// is one of the retry gRPC codes.
+func (r *Retry) hasRetryGRPCStatus(header http.Header) bool {
+	value := header.Get("Grpc-Status")
+	if value == "" {
+		value = header.Get(http.TrailerPrefix + "Grpc-Status")
+	}
+	code, err := strconv.Atoi(value)
+	return err == nil && r.grpcRetryCodes[code]
+}
+
+// hasRetryHeader reports whether any of the headers configured to trigger a retry has the expected value.
+func (r *Retry) hasRetryHeader(header http.Header) bool {
+	for name, value := range r.retryOnHeader {
+		if values, ok := header[http.CanonicalHeaderKey(name)]; ok && len(values) > 0 && values[0] == value {
+			return true
+		}
+	}
+	return false
+}
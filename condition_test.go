@@ -0,0 +1,723 @@
+package plugindemo_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/madshargreave/traefik-sleep"
+)
+
+func TestRetryStatusCodes(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		status   int
+		expected int
+	}{
+		{desc: "too many requests", status: http.StatusTooManyRequests, expected: 3},
+		{desc: "service unavailable", status: http.StatusServiceUnavailable, expected: 3},
+		{desc: "internal server error", status: http.StatusInternalServerError, expected: 1},
+		{desc: "ok", status: http.StatusOK, expected: 1},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			cfg := plugindemo.CreateConfig()
+			cfg.Attempts = 3
+			cfg.RetryStatusCodes = []int{http.StatusTooManyRequests, http.StatusServiceUnavailable}
+
+			calls := 0
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				rw.WriteHeader(test.status)
+			})
+
+			recorder := serve(t, cfg, next, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+			assertStatus(t, recorder, test.status)
+			if calls != test.expected {
+				t.Errorf("invalid number of attempts: %d", calls)
+			}
+		})
+	}
+}
+
+func TestRetryStatusCodesUnset(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		codes    []int
+		expected int
+		log      bool
+	}{
+		{desc: "nil", codes: nil, expected: 3, log: true},
+		{desc: "empty", codes: []int{}, expected: 1},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			output := captureLog(t)
+
+			cfg := plugindemo.CreateConfig()
+			cfg.Attempts = 3
+			cfg.RetryStatusCodes = test.codes
+
+			calls := 0
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				rw.WriteHeader(http.StatusServiceUnavailable)
+			})
+
+			serve(t, cfg, next, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+			if calls != test.expected {
+				t.Errorf("invalid number of attempts: %d", calls)
+			}
+			if logged := strings.Contains(output.String(), "no retry condition configured"); logged != test.log {
+				t.Errorf("invalid default retry condition log: %q", output.String())
+			}
+		})
+	}
+}
+
+func TestDefaultRetryConditionLog(t *testing.T) {
+	testCases := []struct {
+		desc   string
+		config func(cfg *plugindemo.Config)
+		log    bool
+	}{
+		{desc: "no condition", config: func(cfg *plugindemo.Config) {}, log: true},
+		{desc: "single attempt", config: func(cfg *plugindemo.Config) { cfg.Attempts = 1 }},
+		{desc: "status ranges", config: func(cfg *plugindemo.Config) { cfg.RetryStatusRanges = []string{"502-504"} }},
+		{desc: "status attempts", config: func(cfg *plugindemo.Config) { cfg.StatusAttempts = map[int]int{503: 2} }},
+		{desc: "header", config: func(cfg *plugindemo.Config) { cfg.RetryOnHeader = map[string]string{"X-Retry": "true"} }},
+		{desc: "body", config: func(cfg *plugindemo.Config) { cfg.RetryOnBodyContains = []string{"warming up"} }},
+		{desc: "empty body", config: func(cfg *plugindemo.Config) { cfg.RetryOnEmptyBody = true }},
+		{desc: "error", config: func(cfg *plugindemo.Config) { cfg.RetryOnError = true }},
+		{desc: "error only", config: func(cfg *plugindemo.Config) { cfg.RetryOnErrorOnly = true }},
+		{desc: "panic", config: func(cfg *plugindemo.Config) { cfg.RetryOnPanic = true }},
+		{desc: "grpc", config: func(cfg *plugindemo.Config) { cfg.GRPCMode = true }},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			output := captureLog(t)
+
+			cfg := plugindemo.CreateConfig()
+			cfg.Attempts = 3
+			test.config(cfg)
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+			if _, err := plugindemo.New(context.Background(), next, cfg, "demo-plugin"); err != nil {
+				t.Fatal(err)
+			}
+
+			if logged := strings.Contains(output.String(), "no retry condition configured"); logged != test.log {
+				t.Errorf("invalid default retry condition log: %q", output.String())
+			}
+			if strings.Contains(output.String(), "WARN") {
+				t.Errorf("default retry condition logged as a warning: %q", output.String())
+			}
+		})
+	}
+}
+
+func TestInvalidRetryStatusCodes(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 3
+	cfg.RetryStatusCodes = []int{503, 600}
+
+	if _, err := plugindemo.New(context.Background(), http.NotFoundHandler(), cfg, "demo-plugin"); err == nil {
+		t.Error("expected an error for an invalid retry status code")
+	}
+}
+
+func TestRetryStatusRanges(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		ranges   []string
+		status   int
+		expected int
+	}{
+		{desc: "in range", ranges: []string{"500-504"}, status: http.StatusBadGateway, expected: 3},
+		{desc: "range bound", ranges: []string{"500-504"}, status: http.StatusGatewayTimeout, expected: 3},
+		{desc: "out of range", ranges: []string{"500-504"}, status: http.StatusHTTPVersionNotSupported, expected: 1},
+		{desc: "single status", ranges: []string{"429"}, status: http.StatusTooManyRequests, expected: 3},
+		{desc: "single status excludes 5xx", ranges: []string{"429"}, status: http.StatusServiceUnavailable, expected: 1},
+		{desc: "combined status", ranges: []string{"429,500-599"}, status: http.StatusTooManyRequests, expected: 3},
+		{desc: "combined range", ranges: []string{"429,500-599"}, status: http.StatusHTTPVersionNotSupported, expected: 3},
+		{desc: "combined ok", ranges: []string{"429,500-599"}, status: http.StatusOK, expected: 1},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			cfg := plugindemo.CreateConfig()
+			cfg.Attempts = 3
+			cfg.RetryStatusRanges = test.ranges
+
+			calls := 0
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				rw.WriteHeader(test.status)
+			})
+
+			recorder := serve(t, cfg, next, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+			assertStatus(t, recorder, test.status)
+			if calls != test.expected {
+				t.Errorf("invalid number of attempts: %d", calls)
+			}
+		})
+	}
+}
+
+func TestInvalidRetryStatusRanges(t *testing.T) {
+	for _, ranges := range []string{"500-abc", "", "600", "504-500", "500-504-599", "429,"} {
+		cfg := plugindemo.CreateConfig()
+		cfg.Attempts = 3
+		cfg.RetryStatusRanges = []string{ranges}
+
+		if _, err := plugindemo.New(context.Background(), http.NotFoundHandler(), cfg, "demo-plugin"); err == nil {
+			t.Errorf("expected an error for the retry status range %q", ranges)
+		}
+	}
+}
+
+func TestStatusAttempts(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		status   int
+		expected int
+	}{
+		{desc: "service unavailable", status: http.StatusServiceUnavailable, expected: 5},
+		{desc: "bad gateway", status: http.StatusBadGateway, expected: 2},
+		{desc: "unlisted status", status: http.StatusInternalServerError, expected: 3},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			cfg := plugindemo.CreateConfig()
+			cfg.Attempts = 3
+			cfg.StatusAttempts = map[int]int{http.StatusServiceUnavailable: 5, http.StatusBadGateway: 2}
+
+			calls := 0
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				rw.WriteHeader(test.status)
+			})
+
+			recorder := serve(t, cfg, next, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+			assertStatus(t, recorder, test.status)
+			if calls != test.expected {
+				t.Errorf("invalid number of attempts: %d", calls)
+			}
+		})
+	}
+}
+
+func TestInvalidStatusAttempts(t *testing.T) {
+	for _, statusAttempts := range []map[int]int{{600: 2}, {503: 0}, {503: 11}} {
+		cfg := plugindemo.CreateConfig()
+		cfg.Attempts = 3
+		cfg.StatusAttempts = statusAttempts
+
+		if _, err := plugindemo.New(context.Background(), http.NotFoundHandler(), cfg, "demo-plugin"); err == nil {
+			t.Errorf("expected an error for the status attempts %v", statusAttempts)
+		}
+	}
+}
+
+func TestRetryContentTypes(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		contentType string
+		expected    int
+	}{
+		{desc: "grpc", contentType: "application/grpc", expected: 1},
+		{desc: "html", contentType: "text/html; charset=utf-8", expected: 3},
+		{desc: "none", expected: 1},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			cfg := plugindemo.CreateConfig()
+			cfg.Attempts = 3
+			cfg.RetryContentTypes = []string{"text/html"}
+
+			calls := 0
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				if test.contentType != "" {
+					rw.Header().Set("Content-Type", test.contentType)
+				}
+				rw.WriteHeader(http.StatusServiceUnavailable)
+			})
+
+			recorder := serve(t, cfg, next, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+			assertStatus(t, recorder, http.StatusServiceUnavailable)
+			if calls != test.expected {
+				t.Errorf("invalid number of attempts: %d", calls)
+			}
+		})
+	}
+}
+
+func TestRetryOnBodyContains(t *testing.T) {
+	testCases := []struct {
+		desc            string
+		maxInspectBytes int64
+		expected        int
+		body            string
+	}{
+		{desc: "starting", expected: 2, body: `{"status":"ready"}`},
+		{desc: "beyond the inspected bytes", maxInspectBytes: 4, expected: 1, body: `{"status":"starting"}`},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			cfg := plugindemo.CreateConfig()
+			cfg.Attempts = 3
+			cfg.RetryOnBodyContains = []string{`"status":"starting"`}
+			if test.maxInspectBytes > 0 {
+				cfg.MaxInspectBytes = test.maxInspectBytes
+			}
+
+			calls := 0
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				if calls == 1 {
+					_, _ = rw.Write([]byte(`{"status":"starting"}`))
+					return
+				}
+				_, _ = rw.Write([]byte(`{"status":"ready"}`))
+			})
+
+			recorder := serve(t, cfg, next, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+			assertStatus(t, recorder, http.StatusOK)
+			if recorder.Body.String() != test.body {
+				t.Errorf("invalid body: %q", recorder.Body.String())
+			}
+			if calls != test.expected {
+				t.Errorf("invalid number of attempts: %d", calls)
+			}
+		})
+	}
+}
+
+func TestRetryOnEmptyBody(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		method   string
+		status   int
+		expected int
+	}{
+		{desc: "empty 200", method: http.MethodGet, status: http.StatusOK, expected: 2},
+		{desc: "204", method: http.MethodGet, status: http.StatusNoContent, expected: 1},
+		{desc: "HEAD request", method: http.MethodHead, status: http.StatusOK, expected: 1},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			cfg := plugindemo.CreateConfig()
+			cfg.Attempts = 3
+			cfg.RetryOnEmptyBody = true
+
+			calls := 0
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				if calls == 1 {
+					rw.WriteHeader(test.status)
+					return
+				}
+				_, _ = rw.Write([]byte("ready"))
+			})
+
+			recorder := serve(t, cfg, next, httptest.NewRequest(test.method, "http://localhost", nil))
+
+			if calls != test.expected {
+				t.Errorf("invalid number of attempts: %d", calls)
+			}
+			if test.expected == 2 && recorder.Body.String() != "ready" {
+				t.Errorf("invalid body: %q", recorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestGRPCMode(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 3
+	cfg.GRPCMode = true
+
+	calls := 0
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		rw.Header().Set("Content-Type", "application/grpc")
+		rw.Header().Set("Trailer", "Grpc-Status")
+		rw.WriteHeader(http.StatusOK)
+		_, _ = rw.Write([]byte("message"))
+		rw.(http.Flusher).Flush()
+		if calls < 3 {
+			rw.Header().Set("Grpc-Status", "14")
+		} else {
+			rw.Header().Set("Grpc-Status", "0")
+		}
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/service/Method", nil)
+	req.Header.Set("Content-Type", "application/grpc")
+	recorder := serve(t, cfg, next, req)
+
+	assertStatus(t, recorder, http.StatusOK)
+	if calls != 3 {
+		t.Errorf("invalid number of attempts: %d", calls)
+	}
+	// The trailers of the last attempt are still sent.
+	if status := recorder.Result().Trailer.Get("Grpc-Status"); status != "0" {
+		t.Errorf("invalid grpc-status trailer: %q", status)
+	}
+}
+
+func TestRetryIdempotentOnly(t *testing.T) {
+	testCases := []struct {
+		desc           string
+		method         string
+		idempotentOnly bool
+		expected       int
+	}{
+		{desc: "POST with idempotent only", method: http.MethodPost, idempotentOnly: true, expected: 1},
+		{desc: "PATCH with idempotent only", method: http.MethodPatch, idempotentOnly: true, expected: 1},
+		{desc: "DELETE with idempotent only", method: http.MethodDelete, idempotentOnly: true, expected: 3},
+		{desc: "POST without idempotent only", method: http.MethodPost, idempotentOnly: false, expected: 3},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			cfg := plugindemo.CreateConfig()
+			cfg.Attempts = 3
+			cfg.RetryIdempotentOnly = test.idempotentOnly
+
+			calls := 0
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				rw.WriteHeader(http.StatusServiceUnavailable)
+			})
+
+			serve(t, cfg, next, httptest.NewRequest(test.method, "http://localhost", nil))
+
+			if calls != test.expected {
+				t.Errorf("invalid number of attempts: %d", calls)
+			}
+		})
+	}
+}
+
+func TestRetryOnlyIfHeader(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		header   string
+		expected int
+	}{
+		{desc: "with header", header: "1", expected: 3},
+		{desc: "without header", expected: 1},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			cfg := plugindemo.CreateConfig()
+			cfg.Attempts = 3
+			cfg.RetryOnlyIfHeader = "X-Allow-Retry"
+
+			calls := 0
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				rw.WriteHeader(http.StatusServiceUnavailable)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+			if test.header != "" {
+				req.Header.Set("X-Allow-Retry", test.header)
+			}
+			serve(t, cfg, next, req)
+
+			if calls != test.expected {
+				t.Errorf("invalid number of attempts: %d", calls)
+			}
+		})
+	}
+}
+
+func TestRetryOnHeader(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 3
+	cfg.RetryOnHeader = map[string]string{"x-backend-cold": "true"}
+
+	calls := 0
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		if calls == 1 {
+			rw.Header().Set("X-Backend-Cold", "true")
+		} else {
+			rw.Header().Set("X-Backend-Cold", "false")
+		}
+		_, _ = rw.Write([]byte("ok"))
+	})
+
+	recorder := serve(t, cfg, next, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+	assertStatus(t, recorder, http.StatusOK)
+	assertHeader(t, recorder.Header(), "X-Backend-Cold", "false")
+	if calls != 2 {
+		t.Errorf("invalid number of attempts: %d", calls)
+	}
+}
+
+func TestBypass(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		method   string
+		path     string
+		expected int
+	}{
+		{desc: "included", method: http.MethodGet, path: "/api/items", expected: 3},
+		{desc: "included lowercase method", method: http.MethodHead, path: "/api/items", expected: 3},
+		{desc: "method not included", method: http.MethodPut, path: "/api/items", expected: 1},
+		{desc: "excluded path", method: http.MethodGet, path: "/health", expected: 1},
+		{desc: "excluded pattern", method: http.MethodGet, path: "/api/ready", expected: 1},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			cfg := plugindemo.CreateConfig()
+			cfg.Attempts = 3
+			cfg.IncludeMethods = []string{http.MethodGet, "head"}
+			cfg.ExcludePaths = []string{"/health", "/api/rea*"}
+
+			calls := 0
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				rw.WriteHeader(http.StatusServiceUnavailable)
+			})
+
+			recorder := serve(t, cfg, next, httptest.NewRequest(test.method, "http://localhost"+test.path, nil))
+
+			assertStatus(t, recorder, http.StatusServiceUnavailable)
+			if calls != test.expected {
+				t.Errorf("invalid number of attempts: %d", calls)
+			}
+		})
+	}
+}
+
+func TestInvalidExcludePaths(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 1
+	cfg.ExcludePaths = []string{"/health["}
+
+	if _, err := plugindemo.New(context.Background(), http.NotFoundHandler(), cfg, "demo-plugin"); err == nil {
+		t.Error("expected an error for an invalid exclude path")
+	}
+}
+
+func TestRetryDecider(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 5
+
+	var statuses []int
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		status := http.StatusOK
+		if len(statuses)%2 == 0 {
+			status = http.StatusTeapot
+		}
+		statuses = append(statuses, status)
+		rw.WriteHeader(status)
+	})
+
+	var decisions []int
+	decider := func(resp plugindemo.AttemptResponse, req *http.Request, attempt int) bool {
+		decisions = append(decisions, attempt)
+		return attempt%2 == 0
+	}
+
+	handler, err := plugindemo.NewWithRetryDecider(context.Background(), next, cfg, "demo-plugin", decider)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+	assertStatus(t, recorder, http.StatusOK)
+	if len(statuses) != 2 || statuses[0] != http.StatusTeapot {
+		t.Errorf("invalid attempts: %v", statuses)
+	}
+	if len(decisions) != 2 || decisions[0] != 2 || decisions[1] != 3 {
+		t.Errorf("invalid decisions: %v", decisions)
+	}
+}
+
+func TestRetryOnError(t *testing.T) {
+	testCases := []struct {
+		desc         string
+		retryOnError bool
+		errorOnly    bool
+		fail         func(rw http.ResponseWriter)
+		expected     int
+	}{
+		{
+			desc:         "panic",
+			retryOnError: true,
+			errorOnly:    true,
+			fail:         func(rw http.ResponseWriter) { panic("backend unreachable") },
+			expected:     3,
+		},
+		{
+			desc:         "nothing written",
+			retryOnError: true,
+			errorOnly:    true,
+			fail:         func(rw http.ResponseWriter) {},
+			expected:     3,
+		},
+		{
+			desc:         "status",
+			retryOnError: true,
+			errorOnly:    true,
+			fail:         func(rw http.ResponseWriter) { rw.WriteHeader(http.StatusServiceUnavailable) },
+			expected:     1,
+		},
+		{
+			desc:         "status retried",
+			retryOnError: true,
+			fail:         func(rw http.ResponseWriter) { rw.WriteHeader(http.StatusServiceUnavailable) },
+			expected:     3,
+		},
+		{
+			desc:     "panic not retried",
+			fail:     func(rw http.ResponseWriter) { panic("backend unreachable") },
+			expected: 1,
+		},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			cfg := plugindemo.CreateConfig()
+			cfg.Attempts = 3
+			cfg.RetryOnError = test.retryOnError
+			cfg.RetryOnErrorOnly = test.errorOnly
+
+			calls := 0
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				test.fail(rw)
+			})
+
+			serve(t, cfg, next, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+			if calls != test.expected {
+				t.Errorf("invalid number of attempts: %d", calls)
+			}
+		})
+	}
+}
+
+func TestRetryOnErrorRecovers(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 2
+	cfg.RetryOnError = true
+
+	calls := 0
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		if calls == 1 {
+			_, _ = rw.Write([]byte("partial"))
+			panic("backend unreachable")
+		}
+		_, _ = rw.Write([]byte("ok"))
+	})
+
+	recorder := serve(t, cfg, next, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+	assertStatus(t, recorder, http.StatusOK)
+	if recorder.Body.String() != "ok" {
+		t.Errorf("invalid body: %q", recorder.Body.String())
+	}
+}
+
+func TestRetryProbability(t *testing.T) {
+	tests := []struct {
+		desc        string
+		probability float64
+		min, max    int
+	}{
+		{desc: "unset", probability: 0, min: 1000, max: 1000},
+		{desc: "always", probability: 1, min: 1000, max: 1000},
+		{desc: "half", probability: 0.5, min: 400, max: 600},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			cfg := plugindemo.CreateConfig()
+			cfg.Attempts = 2
+			cfg.AccessLog = false
+			cfg.RetryProbability = test.probability
+
+			handler := plugindemo.NewForTest(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				rw.WriteHeader(http.StatusServiceUnavailable)
+			}), cfg)
+
+			retried := 0
+			for i := 0; i < 1000; i++ {
+				handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+				if handler.AttemptsMade() == 2 {
+					retried++
+				}
+			}
+			if retried < test.min || retried > test.max {
+				t.Errorf("invalid number of retried requests: %d", retried)
+			}
+		})
+	}
+}
+
+func TestRetryProbabilityUnset(t *testing.T) {
+	cfg := &plugindemo.Config{Attempts: 3}
+
+	calls := 0
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	// A configuration not made by CreateConfig retries all the failed attempts, on their status too.
+	serve(t, cfg, next, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+	if calls != 3 {
+		t.Errorf("invalid number of attempts: %d", calls)
+	}
+}
+
+func TestInvalidRetryProbability(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 2
+	cfg.RetryProbability = 1.5
+
+	if _, err := plugindemo.New(context.Background(), http.NotFoundHandler(), cfg, "demo-plugin"); err == nil {
+		t.Error("expected an error for a retry probability above 1")
+	}
+}
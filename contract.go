@@ -0,0 +1,39 @@
+package plugindemo
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// errorContractBody is the body of the response replacing the last failed attempt when the error contract is enabled.
+type errorContractBody struct {
+	Error     string `json:"error"`
+	RequestID string `json:"requestId"`
+	Attempts  int    `json:"attempts"`
+}
+
+// errorContract returns the standardized response used in place of last, the last of the given attempts of req,
+// the status of last being kept in the X-Upstream-Status header.
+func (r *Retry) errorContract(req *http.Request, last *statusWriter, attempts int) *statusWriter {
+	body, _ := json.Marshal(errorContractBody{
+		Error:     "upstream_unavailable",
+		RequestID: RequestID(req.Context()),
+		Attempts:  attempts,
+	})
+
+	sw := newStatusWriter()
+	sw.Header().Set("Content-Type", "application/json")
+	sw.Header().Set("X-Upstream-Status", strconv.Itoa(last.StatusCode()))
+	sw.WriteHeader(r.errorContractStatus)
+	_, _ = sw.Write(body)
+	return sw
+}
+
+// errorContractStatus returns the status of the standardized error, zero when the error contract is disabled.
+func errorContractStatus(config *Config) int {
+	if !config.ErrorContract {
+		return 0
+	}
+	return config.ErrorContractStatus
+}
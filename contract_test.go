@@ -0,0 +1,68 @@
+package plugindemo_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/madshargreave/traefik-sleep"
+)
+
+func TestErrorContract(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 3
+	cfg.ErrorContract = true
+	cfg.ErrorContractStatus = http.StatusBadGateway
+	cfg.RetriesExhaustedStatus = http.StatusGatewayTimeout
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "text/html")
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = rw.Write([]byte("<h1>Backend starting</h1>"))
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.Header.Set("X-Request-Id", "req-1")
+	recorder := serve(t, cfg, next, req)
+
+	assertStatus(t, recorder, http.StatusBadGateway)
+	assertHeader(t, recorder.Header(), "Content-Type", "application/json")
+	assertHeader(t, recorder.Header(), "X-Upstream-Status", "503")
+	expected := `{"error":"upstream_unavailable","requestId":"req-1","attempts":3}`
+	if recorder.Body.String() != expected {
+		t.Errorf("invalid body: %s", recorder.Body.String())
+	}
+}
+
+func TestErrorContractSingleAttempt(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 1
+	cfg.ErrorContract = true
+
+	recorder := serve(t, cfg, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusBadGateway)
+	}), httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+	// The failed response is normalized, although it was not retried.
+	assertStatus(t, recorder, http.StatusServiceUnavailable)
+	assertHeader(t, recorder.Header(), "X-Upstream-Status", "502")
+	if !strings.Contains(recorder.Body.String(), `"attempts":1`) {
+		t.Errorf("invalid body: %s", recorder.Body.String())
+	}
+}
+
+func TestErrorContractSuccess(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 3
+	cfg.ErrorContract = true
+
+	recorder := serve(t, cfg, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte("ok"))
+	}), httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+	assertStatus(t, recorder, http.StatusOK)
+	if recorder.Header().Get("X-Upstream-Status") != "" || recorder.Body.String() != "ok" {
+		t.Errorf("successful response replaced: %q", recorder.Body.String())
+	}
+}
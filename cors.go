@@ -0,0 +1,23 @@
+package plugindemo
+
+import "net/http"
+
+// isPreflight reports whether req is a CORS preflight request answered by the plugin.
+func (r *Retry) isPreflight(req *http.Request) bool {
+	return r.handleCORSPreflight && req.Method == http.MethodOptions && req.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// writePreflight answers a CORS preflight request with the configured CORS headers.
+func (r *Retry) writePreflight(rw http.ResponseWriter) {
+	header := rw.Header()
+	if r.allowOrigin != "" {
+		header.Set("Access-Control-Allow-Origin", r.allowOrigin)
+	}
+	if r.allowMethods != "" {
+		header.Set("Access-Control-Allow-Methods", r.allowMethods)
+	}
+	if r.allowHeaders != "" {
+		header.Set("Access-Control-Allow-Headers", r.allowHeaders)
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
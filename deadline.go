@@ -0,0 +1,70 @@
+package plugindemo
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// timeoutFor returns the time allowed to serve req: the budget in milliseconds requested by the client
+// in the deadline header when configured and valid, capped to the max deadline, or the timeout otherwise.
+func (r *Retry) timeoutFor(req *http.Request) time.Duration {
+	if r.deadlineHeader == "" {
+		return r.timeout
+	}
+	value := req.Header.Get(r.deadlineHeader)
+	if value == "" {
+		return r.timeout
+	}
+	ms, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || ms <= 0 {
+		return r.timeout
+	}
+	if r.maxDeadline > 0 && ms > r.maxDeadline.Milliseconds() {
+		return r.maxDeadline
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+func parseAttemptTimeouts(values []string) ([]time.Duration, error) {
+	timeouts := make([]time.Duration, 0, len(values))
+	for _, value := range values {
+		timeout, err := parseDuration("attempt timeout", value)
+		if err != nil {
+			return nil, err
+		}
+		if timeout <= 0 {
+			return nil, fmt.Errorf("incorrect value for attempt timeout (%s)", value)
+		}
+		timeouts = append(timeouts, timeout)
+	}
+	return timeouts, nil
+}
+
+// attemptTimeout returns the timeout of the given attempt, zero when unlimited.
+func (r *Retry) attemptTimeout(attempt int) time.Duration {
+	if len(r.attemptTimeouts) == 0 {
+		return 0
+	}
+	if attempt > len(r.attemptTimeouts) {
+		return r.attemptTimeouts[len(r.attemptTimeouts)-1]
+	}
+	return r.attemptTimeouts[attempt-1]
+}
+
+// attemptContext returns the context of the given attempt, derived from ctx and bounded by the attempt timeout.
+func (r *Retry) attemptContext(ctx context.Context, attempt int) (context.Context, context.CancelFunc) {
+	timeout := r.attemptTimeout(attempt)
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// timedOut reports whether an attempt made with ctx, derived from parent, ended without response
+// because its own timeout expired rather than the deadline of the whole request.
+func timedOut(sw *statusWriter, parent, ctx context.Context) bool {
+	return parent.Err() == nil && ctx.Err() == context.DeadlineExceeded && sw.connectionError()
+}
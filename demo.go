@@ -3,20 +3,328 @@ package plugindemo
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// defaultMaxAttempts is the upper bound of Attempts when MaxAttempts is unset.
+const defaultMaxAttempts = 10
+
 // Config the plugin configuration.
 type Config struct {
 	Attempts int
+	// MaxAttempts is the upper bound accepted for Attempts, 10 when unset.
+	MaxAttempts int
+	// Delay to wait before forwarding the request, e.g. "500ms".
+	Delay string
+	// AllowHeaderDelay lets clients replace Delay with the duration in DelayHeader.
+	AllowHeaderDelay bool
+	// DelayHeader is the request header holding the delay, e.g. "X-Wake-Delay".
+	DelayHeader string
+	// MaxDelay caps the delay requested through DelayHeader.
+	MaxDelay string
+	// BackoffBase is the wait before the first retry, grown on each following retry according to BackoffStrategy.
+	BackoffBase string
+	// BackoffStrategy is how the backoff grows between retries:
+	// "constant", "linear" or "exponential" (doubled on each retry).
+	BackoffStrategy string
+	// BackoffMax caps the wait between two attempts.
+	BackoffMax string
+	// Jitter randomizes each backoff by up to this fraction (0.0 to 1.0) in either direction.
+	Jitter float64
+	// AdaptiveBackoff multiplies the backoff of all the requests by a factor doubled on each failed request
+	// while the rolling failure rate is above AdaptiveBackoffThreshold (0.0 to 1.0), up to AdaptiveBackoffMaxFactor,
+	// and halved on each successful request. The backoff grown this way may exceed BackoffMax.
+	AdaptiveBackoff          bool
+	AdaptiveBackoffThreshold float64
+	AdaptiveBackoffMaxFactor float64
+	// RetryStatusCodes lists the statuses to retry on, all 5xx statuses when not set.
+	// An empty list retries on no status.
+	RetryStatusCodes []int
+	// RetryStatusRanges lists more statuses to retry on as comma separated codes or ranges, such as "429,500-504".
+	RetryStatusRanges []string
+	// StatusAttempts are the attempts allowed for the responses of some statuses, in place of Attempts,
+	// such as 5 attempts for a 503 but only 2 for a 502.
+	StatusAttempts map[int]int
+	// RetryOnErrorOnly disables the retries of the responses with a retryable status, or header,
+	// only the attempts failing as enabled by RetryOnError being retried.
+	RetryOnErrorOnly bool
+	// RetryOnError retries the attempts that failed without a response from the backend:
+	// those whose handler panicked, was abandoned, or returned without writing anything.
+	RetryOnError bool
+	// RetryOnPanic retries the attempts whose handler panicked, which otherwise get a 500.
+	RetryOnPanic bool
+	// RetryOnHeader retries the responses having any of these headers set to the given value,
+	// in addition to the ones with a retryable status.
+	RetryOnHeader map[string]string
+	// RetryContentTypes restricts the responses retried for their status to those of these content types.
+	RetryContentTypes []string
+	// RetryOnBodyContains retries the responses whose body contains any of these strings,
+	// such as a 200 reporting that the backend is still starting.
+	RetryOnBodyContains []string
+	// RetryOnEmptyBody retries the 200 responses without a body, which some backends send before being ready.
+	// The responses to HEAD requests, which never have a body, are not retried.
+	RetryOnEmptyBody bool
+	// GRPCMode retries the gRPC responses whose grpc-status is one of GRPCRetryCodes.
+	// The responses are buffered until the end of each attempt, their status coming in the trailers.
+	GRPCMode       bool
+	GRPCRetryCodes []int
+	// MaxInspectBytes is the length of the start of the body searched for RetryOnBodyContains, all of it when zero.
+	MaxInspectBytes int64
+	// TracingEnabled creates spans around each request and each of its attempts,
+	// using the tracer given to NewWithTracer.
+	TracingEnabled bool
+	// RetryProbability is the probability (0.0 to 1.0) that a failed attempt is actually retried,
+	// to partially enable the retries during a rollout. The failed attempts are always retried when zero.
+	RetryProbability float64
+	// DryRun logs the retries that would be made, without making them.
+	DryRun bool
+	// IncludeMethods restricts the middleware to the requests with one of these methods, all of them when empty.
+	IncludeMethods []string
+	// ExcludePaths are path patterns, as understood by path.Match, of the requests forwarded as is.
+	ExcludePaths []string
+	// AttemptLatencyBuckets are the upper bounds, in seconds, of the buckets of the attempt latency histogram.
+	AttemptLatencyBuckets []float64
+	// Rules override Attempts, Delay and RetryStatusCodes for some path prefixes,
+	// the longest matching prefix taking precedence.
+	Rules []RuleConfig
+	// HostPolicies override Attempts, Delay, RetryStatusCodes and HealthCheckURL for some hosts,
+	// matched without port, in place of the rules. Each host has its own warm state.
+	HostPolicies map[string]RuleConfig
+	// PathRegexDelays override the delay of the policy applying to the requests whose path matches their pattern,
+	// the first matching one taking precedence.
+	PathRegexDelays []PathRegexDelay
+	// LogFormat of the access log, either "text" or "json".
+	LogFormat string
+	// LogLevel is the least severe level of the lines written to the log,
+	// either "debug", "info", "warn" or "error". The access log is written at the info level.
+	LogLevel string
+	// Timeout bounds the total time spent in attempts and backoff.
+	Timeout string
+	// DeadlineHeader is the header holding the time allowed by the client in milliseconds, overriding Timeout.
+	DeadlineHeader string
+	// MaxDeadline caps the time requested in the deadline header.
+	MaxDeadline string
+	// AttemptTimeouts bound each attempt, the Nth entry applying to the Nth attempt
+	// and the last one to the attempts beyond, an attempt timing out without response failing with a 504.
+	// A handler ignoring the context of a timed out attempt is abandoned, unless its response was already sent.
+	AttemptTimeouts []string
+	// MinResponseTime holds the successful responses until this long after the request was received,
+	// so that warm and cold responses take about the same time.
+	MinResponseTime string
+	// FirstByteTimeout abandons an attempt whose response did not start within this duration,
+	// the attempt failing with a 504 eligible for retry.
+	FirstByteTimeout string
+	// HealthCheckURL is polled before forwarding until it responds with 200,
+	// to wake a backend that was scaled to zero.
+	HealthCheckURL string
+	// HealthCheckHeaders are set on the health check requests, such as an Accept or Authorization header.
+	HealthCheckHeaders map[string]string
+	// HealthCheckTimeout bounds each health check poll, a poll timing out counting as an unhealthy one.
+	// The polls remain limited to Attempts per wake.
+	HealthCheckTimeout string
+	// HealthCheckInterval is the wait between two health check polls.
+	HealthCheckInterval string
+	// HealthCheckJitter bounds the random wait before the first health check poll.
+	HealthCheckJitter string
+	// PrewarmOnStart requests the health check URL once when the plugin is created,
+	// to wake the backend before the first request.
+	PrewarmOnStart bool
+	// KeepWarmInterval is the wait between two requests to the health check URL,
+	// made in the background to keep the backend from scaling to zero.
+	KeepWarmInterval string
+	// WakeHoldingPage is the HTML page answered right away, with a Retry-After header, to the requests
+	// reaching a cold backend, which is woken up in the background. It requires HealthCheckURL and WakeCacheTTL.
+	WakeHoldingPage string
+	// WakeHoldingStatus is the status of the holding page.
+	WakeHoldingStatus int
+	// ServeStaleWhileWaking answers the GET requests reaching a cold backend with their last successful response,
+	// marked with an X-Served-Stale header, when it is younger than StaleTTL, the backend being woken up in the background.
+	// It requires WakeCacheTTL. The cached responses are limited to MaxStaleBytes in total, unlimited when zero.
+	ServeStaleWhileWaking bool
+	StaleTTL              string
+	MaxStaleBytes         int64
+	// HonorIdempotencyKey answers the requests with an Idempotency-Key header with the first successful response
+	// to the same key and host within IdempotencyKeyTTL, instead of forwarding them again,
+	// marked with an Idempotent-Replayed header. The requests with the same key in flight at once are all forwarded.
+	HonorIdempotencyKey bool
+	IdempotencyKeyTTL   string
+	// WakeCacheTTL is how long the backend is considered awake after a successful request,
+	// the following requests skipping the delay and health check meanwhile. Disabled when empty.
+	WakeCacheTTL string
+	// SkipRetryBodyBytes disables retries for the requests with a body larger than this, so that they are not buffered.
+	// Disabled when zero.
+	SkipRetryBodyBytes int64
+	// SkipUnknownLength disables retries for the requests with a body of unknown length when SkipRetryBodyBytes is set,
+	// instead of buffering them up to SkipRetryBodyBytes to find out.
+	SkipUnknownLength bool
+	// MaxBodyBytes limits the size of request bodies buffered for retries, unlimited when zero.
+	MaxBodyBytes int64
+	// RetryIdempotentOnly restricts retries to idempotent methods (GET, HEAD, OPTIONS, PUT and DELETE).
+	RetryIdempotentOnly bool
+	// RetryOnlyIfHeader only retries the requests carrying this header, when not empty.
+	RetryOnlyIfHeader string
+	// PreserveHost forwards each attempt with the Host header of the client, replaced by the host of the request URL otherwise.
+	PreserveHost bool
+	// StripRequestHeaders are removed from the request before each attempt.
+	StripRequestHeaders []string
+	// StripResponseHeaders are removed from the response before it is sent to the client.
+	StripResponseHeaders []string
+	// AttemptHeader is set to the attempt number on each forwarded request when not empty.
+	AttemptHeader string
+	// MaxRetryAfter caps the wait requested by a Retry-After response header, unlimited when empty.
+	MaxRetryAfter string
+	// RequestIDHeader carries the request ID, generated when the request has none.
+	RequestIDHeader string
+	// AccessLog enables the per-request access log line.
+	AccessLog bool
+	// SlowRequestThreshold logs a warning for the requests taking longer than this, whether or not AccessLog is enabled.
+	SlowRequestThreshold string
+	// LogSampleRate logs the access log line of only one in this many failed requests, all of them when 0 or 1.
+	LogSampleRate int
+	// CollapseRequests makes the identical GET requests received while one is in flight share its response,
+	// when it is cacheable, so that a single one reaches a cold backend.
+	// Requests are identical when they have the same URL and Accept, Accept-Encoding, Accept-Language,
+	// Authorization and Cookie headers.
+	CollapseRequests bool
+	// ServerTiming sets a Server-Timing header on the responses, breaking down the time spent
+	// waiting for the backend to wake up, waiting between the attempts, and in the attempts.
+	ServerTiming bool
+	// WarmStateHeader is the response header set to "cold" when the request waited for the backend to wake up,
+	// and to "warm" otherwise.
+	WarmStateHeader string
+	// RetryCountHeader is set to the number of attempts made on the response when not empty.
+	RetryCountHeader string
+	// ByteCountHeader is set to the length of the body sent to the client on the response when not empty.
+	// It is not set on the responses streamed to the client, their headers being sent before the body.
+	ByteCountHeader string
+	// WindowSize is the number of recent requests the circuit breaker tracks, disabled when zero.
+	WindowSize int
+	// FailureThreshold is the failure ratio (0.0 to 1.0) over the window above which the circuit opens.
+	FailureThreshold float64
+	// OpenDuration is how long the circuit stays open before letting a trial request through.
+	OpenDuration string
+	// PerIPRetryLimit is the number of retries allowed for each client IP within PerIPWindow, unlimited when zero.
+	// The retries of a client are forgotten as soon as one of its requests succeeds.
+	PerIPRetryLimit int
+	// PerIPWindow is the sliding window over which retries are counted for each client IP.
+	PerIPWindow string
+	// RetryBudgetPercent limits the retries made for all the clients to this percentage of the requests
+	// within RetryBudgetWindow, unlimited when zero. MinRetriesPerSecond retries are always allowed,
+	// so that a low traffic can still be retried.
+	RetryBudgetPercent  float64
+	MinRetriesPerSecond int
+	RetryBudgetWindow   string
+	// SharedAttempts bounds the attempts made for a request by this plugin and all the plugins it goes through,
+	// which share the budget in the request context, unlimited when zero.
+	SharedAttempts int
+	// MaxConcurrent limits the number of requests served at once, unlimited when zero.
+	MaxConcurrent int
+	// MaxSleepingRequests limits the number of requests waiting for their wake delay or backoff at once,
+	// unlimited when zero. According to OnSleepSaturated, the requests beyond either skip the wait
+	// with "forward", or are rejected with a 503 with "reject".
+	MaxSleepingRequests int
+	OnSleepSaturated    string
+	// MaxConcurrentWakes limits the number of cold backends polled for their health at once, across all the requests,
+	// unlimited when zero. The wakes beyond wait for one of them to complete.
+	MaxConcurrentWakes int
+	// EventBufferSize is the size of the buffer of the channel returned by Events, no event being sent when zero.
+	EventBufferSize int
+	// OnFull is the behavior when MaxConcurrent requests are already being served:
+	// either "queue" to wait for one of them to complete, or "reject" to respond with 503.
+	OnFull string
+	// MaxTotalBufferBytes bounds the request and response bodies buffered by all the requests in flight,
+	// the requests beyond being forwarded once, without being buffered nor retried. Unlimited when zero.
+	MaxTotalBufferBytes int64
+	// MaxResponseBufferBytes limits the size of the response buffered for an attempt, unlimited when zero.
+	// A larger response is sent to the client as it is written, and is not retried.
+	// The limit applies to the body as written by the backend, compressed or not.
+	MaxResponseBufferBytes int64
+	// CompressBuffer keeps the response buffered for an attempt gzip compressed once it is larger than
+	// CompressBufferThreshold, trading some CPU for the memory held by large responses.
+	// The responses with a Content-Encoding are buffered as is.
+	CompressBuffer          bool
+	CompressBufferThreshold int64
+	// StreamingContentTypes are the response content types streamed to the client, without being retried.
+	StreamingContentTypes []string
+	// RetriesExhaustedStatus replaces the status of the last attempt when all the attempts failed,
+	// the last attempt being passed through when zero.
+	RetriesExhaustedStatus int
+	// RetriesExhaustedBody is the body sent along with RetriesExhaustedStatus.
+	RetriesExhaustedBody string
+	// ErrorContract replaces the last attempt, when all the attempts failed, with a standardized JSON error
+	// holding the request ID and the number of attempts, and with ErrorContractStatus, in place of RetriesExhaustedStatus.
+	// The status of the last attempt is kept in the X-Upstream-Status header.
+	ErrorContract       bool
+	ErrorContractStatus int
+	// FallbackURL is the backend the request is sent to when all the attempts failed.
+	// The request path and query are appended to it.
+	FallbackURL string
+	// FallbackURLs are more fallback backends, tried in order after FallbackURL until one does not respond with a 5xx.
+	FallbackURLs []string
+	// MaintenanceHeader puts the requests carrying it in maintenance, as SetMaintenance does for all the requests.
+	MaintenanceHeader string
+	// MaintenanceStatus and MaintenanceBody are the response to the requests in maintenance,
+	// which are not forwarded to the backend. The status is 503 when zero.
+	MaintenanceStatus int
+	MaintenanceBody   string
+	// ActiveHours restricts the forwarded requests to a daily window, always forwarding them when its Start and End are empty.
+	ActiveHours ActiveHours
+	// SleepingStatus and SleepingPage are the response to the requests received outside the active hours,
+	// which are not forwarded to the backend. The status is 503 when zero.
+	SleepingStatus int
+	SleepingPage   string
+	// HandleCORSPreflight answers the CORS preflight requests with a 204 and the Access-Control-Allow headers
+	// set to AllowOrigin, AllowMethods and AllowHeaders, without waking the backend.
+	HandleCORSPreflight bool
+	AllowOrigin         string
+	AllowMethods        string
+	AllowHeaders        string
+	// AdminToken protects the StatusHandler, whose requests must carry it as a bearer token, when not empty.
+	AdminToken string
+	// DrainingStatus is the status of the requests received once Drain was called, 503 when zero.
+	DrainingStatus int
 }
 
 // CreateConfig creates the default plugin configuration.
 func CreateConfig() *Config {
-	return &Config{}
+	return &Config{
+		MaxAttempts:              defaultMaxAttempts,
+		PreserveHost:             true,
+		BackoffStrategy:          backoffExponential,
+		LogFormat:                logFormatText,
+		LogLevel:                 logLevelInfo,
+		RetryIdempotentOnly:      true,
+		MaxRetryAfter:            "10s",
+		RequestIDHeader:          "X-Request-Id",
+		AccessLog:                true,
+		OnFull:                   onFullQueue,
+		OnSleepSaturated:         onSleepSaturatedForward,
+		DelayHeader:              "X-Wake-Delay",
+		MaxDelay:                 "30s",
+		MaxDeadline:              "30s",
+		MaintenanceStatus:        http.StatusServiceUnavailable,
+		SleepingStatus:           http.StatusServiceUnavailable,
+		SleepingPage:             defaultSleepingPage,
+		MaxInspectBytes:          4096,
+		GRPCRetryCodes:           []int{grpcUnavailable},
+		DrainingStatus:           http.StatusServiceUnavailable,
+		WakeHoldingStatus:        http.StatusServiceUnavailable,
+		MaxStaleBytes:            1 << 20,
+		ErrorContractStatus:      http.StatusServiceUnavailable,
+		IdempotencyKeyTTL:        "1m",
+		RetryBudgetWindow:        "10s",
+		CompressBufferThreshold:  64 << 10,
+		AdaptiveBackoffThreshold: 0.5,
+		AdaptiveBackoffMaxFactor: 8,
+	}
 }
 
 // Listener is used to inform about retry attempts.
@@ -30,62 +338,865 @@ type Listener interface {
 // each of them about a retry attempt.
 type Listeners []Listener
 
+// Retried notifies each listener about the retry attempt.
+func (l Listeners) Retried(req *http.Request, attempt int) {
+	for _, listener := range l {
+		listener.Retried(req, attempt)
+	}
+}
+
 // Retry a Demo plugin.
 type Retry struct {
-	attempts int
-	next     http.Handler
-	// listener Listener
-	name string
+	// metrics is kept first to guarantee the 64-bit alignment of its counters.
+	metrics Metrics
+	// failureLogs counts the failed requests, to sample their access log lines. It follows metrics to stay aligned.
+	failureLogs int64
+	// inFlight is the number of requests being served.
+	inFlight int64
+	// sleeping is the number of requests waiting for their wake delay or backoff,
+	// counted only when the max sleeping requests is set.
+	sleeping            int64
+	maxSleepingRequests int64
+	onSleepSaturated    string
+	// attemptsMade is the number of attempts made for the last request, recorded only when forTest is set.
+	attemptsMade int64
+	latency      *histogram
+
+	// ctx is canceled when the plugin is closed.
+	ctx    context.Context
+	cancel context.CancelFunc
+	clock  clock
+	// client sends the requests of the plugin itself, to the health check and fallback URLs.
+	client *http.Client
+	log    *levelLogger
+
+	policiesMu sync.RWMutex
+	policies   *policies
+
+	// includeMethods is the set of methods the middleware acts on, nil for all of them.
+	includeMethods map[string]bool
+	excludePaths   []string
+
+	timeout time.Duration
+	// deadlineHeader is the header holding the client requested timeout, empty when not configured.
+	deadlineHeader string
+	maxDeadline    time.Duration
+	// statusAttempts are the attempts allowed per status, maxStatusAttempts being the largest of them.
+	statusAttempts    map[int]int
+	maxStatusAttempts int
+	minResponseTime   time.Duration
+	// attemptTimeouts are the timeouts of the successive attempts, unlimited when empty.
+	attemptTimeouts []time.Duration
+	// firstByteTimeout is the wait for an attempt to start responding, unlimited when zero.
+	firstByteTimeout time.Duration
+	logFormat        string
+	accessLog        bool
+	// slowRequestThreshold is the duration above which a request is logged as slow, disabled when zero.
+	slowRequestThreshold time.Duration
+	logSampleRate        int
+	next                 http.Handler
+	listener             Listener
+	events               chan RetryEvent
+	name                 string
+
+	healthCheckURL      string
+	healthCheckHeaders  map[string]string
+	healthCheckInterval time.Duration
+	healthCheckTimeout  time.Duration
+	healthCheckJitter   time.Duration
+	keepWarmInterval    time.Duration
+	prewarmOnStart      bool
+	// wakes shares the wake of the backend between the concurrent requests.
+	wakes flightGroup
+
+	wakeCacheTTL      time.Duration
+	wakeHoldingPage   string
+	wakeHoldingStatus int
+	warmMu            sync.Mutex
+	// warmUntil is the time until which each backend is considered awake.
+	warmUntil map[string]time.Time
+
+	maxBodyBytes        int64
+	skipRetryBodyBytes  int64
+	skipUnknownLength   bool
+	retryIdempotentOnly bool
+	retryOnlyIfHeader   string
+	attemptHeader       string
+	maxRetryAfter       time.Duration
+	requestIDHeader     string
+	retryCountHeader    string
+	byteCountHeader     string
+	warmStateHeader     string
+	serverTiming        bool
+	collapseRequests    bool
+	collapsed           collapseGroup
+
+	preserveHost         bool
+	stripRequestHeaders  []string
+	stripResponseHeaders []string
+
+	circuit  *circuit
+	adaptive *adaptiveBackoff
+	budget   *retryBudget
+	ratio    *retryRatio
+	// stale is the cache of the responses served while the backend wakes up, nil when disabled.
+	stale *staleCache
+	// idempotency is the cache of the responses replayed for the requests with the same idempotency key, nil when disabled.
+	idempotency *staleCache
+	// sharedAttempts is the attempt budget shared with the nested plugins, disabled when zero.
+	sharedAttempts int
+	// retryProbability is the probability that a failed attempt is retried.
+	retryProbability float64
+
+	// slots is the concurrency semaphore, nil when concurrency is unlimited.
+	slots  chan struct{}
+	onFull string
+	// wakeSlots bounds the wakes polling a health check URL at once, nil when unlimited.
+	wakeSlots chan struct{}
+
+	streamingContentTypes  []string
+	memory                 *memoryBudget
+	maxResponseBufferBytes int64
+	compressThreshold      int64
+
+	retriesExhaustedStatus int
+	retriesExhaustedBody   string
+	// errorContractStatus is the status of the standardized error, disabled when zero.
+	errorContractStatus int
+	fallbackURLs        []string
+
+	// maintenance is non-zero while the plugin is in maintenance.
+	maintenance int32
+	// draining is non-zero once Drain was called.
+	draining          int32
+	drainingStatus    int
+	maintenanceHeader string
+	maintenanceStatus int
+	maintenanceBody   string
+
+	// activeHours is the window outside of which the requests get the sleeping page.
+	activeHours    *activeHours
+	sleepingStatus int
+	sleepingPage   string
+
+	adminToken string
+
+	handleCORSPreflight bool
+	allowOrigin         string
+	allowMethods        string
+	allowHeaders        string
+
+	retryOnHeader map[string]string
+	// retryContentTypes are the content types of the responses retried for their status, all of them when empty.
+	retryContentTypes   []string
+	retryOnBodyContains []string
+	retryOnEmptyBody    bool
+	maxInspectBytes     int64
+	grpcMode            bool
+	grpcRetryCodes      map[int]bool
+	retryOnStatus       bool
+	retryOnError        bool
+	retryOnPanic        bool
+	dryRun              bool
+
+	tracer       Tracer
+	decider      RetryDecider
+	preAttempt   PreAttempt
+	postResponse PostResponse
+
+	// delayHeader is the header holding the client requested delay, empty when not allowed.
+	delayHeader string
+	maxDelay    time.Duration
+
+	forTest bool
+
+	// fastPath is set when the requests can be forwarded as is, provided that the policies make a single attempt.
+	fastPath bool
 }
 
-type statusWriter struct {
-	http.ResponseWriter
-	status int
-	length int
+// New created a new Demo plugin.
+func New(ctx context.Context, next http.Handler, config *Config, name string) (http.Handler, error) {
+	r, err := newRetry(ctx, next, config, name, realClock{}, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	r.start()
+	return r, nil
 }
 
-func (w *statusWriter) WriteHeader(status int) {
-	w.status = status
-	w.ResponseWriter.WriteHeader(status)
+// newRetry creates a new Demo plugin telling the time with c, sending its own requests with client,
+// or with a default client when nil, and writing its log to logger, or to the standard logger when nil.
+func newRetry(ctx context.Context, next http.Handler, config *Config, name string, c clock, client *http.Client, logger Logger) (*Retry, error) {
+	config = withEnv(config)
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	ps, err := newPolicies(config)
+	if err != nil {
+		return nil, err
+	}
+	if logger == nil {
+		logger = stdLogger{}
+	}
+	l := newLevelLogger(logger, config.LogLevel)
+	if config.Attempts > 1 && !hasRetryCondition(config) {
+		l.infof("no retry condition configured for %s, retrying all 5xx statuses", name)
+	}
+
+	r := &Retry{
+		clock:              c,
+		client:             client,
+		log:                l,
+		policies:           ps,
+		includeMethods:     parseMethods(config.IncludeMethods),
+		excludePaths:       config.ExcludePaths,
+		logFormat:          config.LogFormat,
+		accessLog:          config.AccessLog,
+		logSampleRate:      config.LogSampleRate,
+		sharedAttempts:     config.SharedAttempts,
+		retryProbability:   config.RetryProbability,
+		next:               next,
+		listener:           Listeners{},
+		name:               name,
+		healthCheckURL:     config.HealthCheckURL,
+		prewarmOnStart:     config.PrewarmOnStart,
+		healthCheckHeaders: config.HealthCheckHeaders,
+
+		wakeHoldingPage:   config.WakeHoldingPage,
+		wakeHoldingStatus: config.WakeHoldingStatus,
+		maxBodyBytes:      config.MaxBodyBytes,
+
+		retryIdempotentOnly: config.RetryIdempotentOnly,
+		retryOnlyIfHeader:   config.RetryOnlyIfHeader,
+		attemptHeader:       config.AttemptHeader,
+		requestIDHeader:     config.RequestIDHeader,
+		retryCountHeader:    config.RetryCountHeader,
+		byteCountHeader:     config.ByteCountHeader,
+		warmStateHeader:     config.WarmStateHeader,
+		serverTiming:        config.ServerTiming,
+		collapseRequests:    config.CollapseRequests,
+		deadlineHeader:      config.DeadlineHeader,
+		onFull:              config.OnFull,
+		maxSleepingRequests: int64(config.MaxSleepingRequests),
+		onSleepSaturated:    config.OnSleepSaturated,
+		skipRetryBodyBytes:  config.SkipRetryBodyBytes,
+		skipUnknownLength:   config.SkipUnknownLength,
+
+		preserveHost:         config.PreserveHost,
+		stripRequestHeaders:  config.StripRequestHeaders,
+		stripResponseHeaders: config.StripResponseHeaders,
+
+		streamingContentTypes:  config.StreamingContentTypes,
+		maxResponseBufferBytes: config.MaxResponseBufferBytes,
+		compressThreshold:      compressThreshold(config),
+
+		retriesExhaustedStatus: config.RetriesExhaustedStatus,
+		retriesExhaustedBody:   config.RetriesExhaustedBody,
+		errorContractStatus:    errorContractStatus(config),
+		fallbackURLs:           fallbackURLs(config),
+
+		maintenanceHeader: config.MaintenanceHeader,
+		maintenanceStatus: statusOrUnavailable(config.MaintenanceStatus),
+		maintenanceBody:   config.MaintenanceBody,
+		sleepingStatus:    statusOrUnavailable(config.SleepingStatus),
+		sleepingPage:      config.SleepingPage,
+		drainingStatus:    statusOrUnavailable(config.DrainingStatus),
+
+		adminToken: config.AdminToken,
+
+		handleCORSPreflight: config.HandleCORSPreflight,
+		allowOrigin:         config.AllowOrigin,
+		allowMethods:        config.AllowMethods,
+		allowHeaders:        config.AllowHeaders,
+
+		retryOnHeader:       config.RetryOnHeader,
+		retryContentTypes:   config.RetryContentTypes,
+		retryOnBodyContains: config.RetryOnBodyContains,
+		retryOnEmptyBody:    config.RetryOnEmptyBody,
+		maxInspectBytes:     config.MaxInspectBytes,
+		grpcMode:            config.GRPCMode,
+		grpcRetryCodes:      parseGRPCCodes(config.GRPCRetryCodes),
+		retryOnStatus:       !config.RetryOnErrorOnly,
+		retryOnError:        config.RetryOnError,
+		retryOnPanic:        config.RetryOnPanic,
+		dryRun:              config.DryRun,
+
+		tracer: noopTracer{},
+	}
+	if config.AllowHeaderDelay {
+		r.delayHeader = config.DelayHeader
+	}
+	if config.MaxConcurrent > 0 {
+		r.slots = make(chan struct{}, config.MaxConcurrent)
+	}
+	if config.EventBufferSize > 0 {
+		r.events = make(chan RetryEvent, config.EventBufferSize)
+	}
+	if config.MaxConcurrentWakes > 0 {
+		r.wakeSlots = make(chan struct{}, config.MaxConcurrentWakes)
+	}
+	if err := r.parseDurations(config); err != nil {
+		return nil, err
+	}
+	if r.attemptTimeouts, err = parseAttemptTimeouts(config.AttemptTimeouts); err != nil {
+		return nil, err
+	}
+	if r.maxStatusAttempts, err = parseStatusAttempts(config.StatusAttempts, config.MaxAttempts); err != nil {
+		return nil, err
+	}
+	if len(config.StatusAttempts) > 0 {
+		r.statusAttempts = config.StatusAttempts
+	}
+	openDuration, err := parseDuration("open duration", config.OpenDuration)
+	if err != nil {
+		return nil, err
+	}
+	if r.circuit, err = newCircuit(config.WindowSize, config.FailureThreshold, openDuration); err != nil {
+		return nil, err
+	}
+	if r.adaptive, err = newAdaptiveBackoff(config); err != nil {
+		return nil, err
+	}
+	if r.memory, err = newMemoryBudget(config.MaxTotalBufferBytes); err != nil {
+		return nil, err
+	}
+	if r.latency, err = newHistogram(config.AttemptLatencyBuckets); err != nil {
+		return nil, err
+	}
+	perIPWindow, err := parseDuration("per IP window", config.PerIPWindow)
+	if err != nil {
+		return nil, err
+	}
+	if r.budget, err = newRetryBudget(config.PerIPRetryLimit, perIPWindow); err != nil {
+		return nil, err
+	}
+	retryBudgetWindow, err := parseDuration("retry budget window", config.RetryBudgetWindow)
+	if err != nil {
+		return nil, err
+	}
+	if r.ratio, err = newRetryRatio(config.RetryBudgetPercent, config.MinRetriesPerSecond, retryBudgetWindow); err != nil {
+		return nil, err
+	}
+	staleTTL, err := parseDuration("stale TTL", config.StaleTTL)
+	if err != nil {
+		return nil, err
+	}
+	r.stale = newStaleCache(config, staleTTL)
+	idempotencyKeyTTL, err := parseDuration("idempotency key TTL", config.IdempotencyKeyTTL)
+	if err != nil {
+		return nil, err
+	}
+	r.idempotency = newIdempotencyCache(config, idempotencyKeyTTL)
+	if r.activeHours, err = newActiveHours(config); err != nil {
+		return nil, err
+	}
+	if r.client == nil {
+		r.client = newClient()
+	}
+	r.fastPath = r.hasFastPath()
+	r.ctx, r.cancel = context.WithCancel(ctx)
+	return r, nil
 }
 
-func (w *statusWriter) Write(b []byte) (int, error) {
-	if w.status == 0 {
-		w.status = 200
+// start starts the background tasks of the plugin.
+func (r *Retry) start() {
+	if r.prewarmOnStart {
+		go r.prewarm()
+	}
+	if r.keepWarmInterval > 0 {
+		go r.keepWarm()
 	}
-	n, err := w.ResponseWriter.Write(b)
-	w.length += n
-	return n, err
 }
 
-// New created a new Demo plugin.
-func New(ctx context.Context, next http.Handler, config *Config, name string) (http.Handler, error) {
-	if config.Attempts <= 0 {
-		return nil, fmt.Errorf("incorrect (or empty) value for attempt (%d)", config.Attempts)
+// NewWithListeners creates a new Demo plugin notifying listeners about retry attempts.
+func NewWithListeners(ctx context.Context, next http.Handler, config *Config, name string, listeners ...Listener) (http.Handler, error) {
+	handler, err := New(ctx, next, config, name)
+	if err != nil {
+		return nil, err
 	}
-	return &Retry{
-		attempts: config.Attempts,
-		next:     next,
-		// listener: listener,
-		name: name,
-	}, nil
+	handler.(*Retry).listener = Listeners(listeners)
+	return handler, nil
+}
+
+// NewWithTracer creates a new Demo plugin tracing requests with tracer when tracing is enabled.
+func NewWithTracer(ctx context.Context, next http.Handler, config *Config, name string, tracer Tracer) (http.Handler, error) {
+	handler, err := New(ctx, next, config, name)
+	if err != nil {
+		return nil, err
+	}
+	if config.TracingEnabled && tracer != nil {
+		handler.(*Retry).tracer = tracer
+		handler.(*Retry).fastPath = false
+	}
+	return handler, nil
+}
+
+// NewWithRetryDecider creates a new Demo plugin deciding whether to retry the responses with decider,
+// in place of the configured statuses and headers.
+func NewWithRetryDecider(ctx context.Context, next http.Handler, config *Config, name string, decider RetryDecider) (http.Handler, error) {
+	handler, err := New(ctx, next, config, name)
+	if err != nil {
+		return nil, err
+	}
+	handler.(*Retry).decider = decider
+	return handler, nil
 }
 
 func (r *Retry) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
-	start := time.Now()
-	sw := statusWriter{ResponseWriter: rw}
-	r.next.ServeHTTP(rw, req)
-	duration := time.Now().Sub(start)
-	log.Printf("host: %v request: %v [%v] (%v)", req.Host, req.URL, sw.status, duration)
-	// Log(LogEntry{
-	// 	Host:       r.Host,
-	// 	RemoteAddr: r.RemoteAddr,
-	// 	Method:     r.Method,
-	// 	RequestURI: r.RequestURI,
-	// 	Proto:      r.Proto,
-	// 	Status:     sw.status,
-	// 	ContentLen: sw.length,
-	// 	UserAgent:  r.Header.Get("User-Agent"),
-	// 	Duration:   duration,
-	// })
+	if r.forTest {
+		atomic.StoreInt64(&r.attemptsMade, 0)
+	}
+	if r.next == nil {
+		writeError(rw, http.StatusInternalServerError, "no next handler")
+		return
+	}
+	atomic.AddInt64(&r.inFlight, 1)
+	defer atomic.AddInt64(&r.inFlight, -1)
+	if r.isDraining() {
+		rw.WriteHeader(r.drainingStatus)
+		return
+	}
+	if r.inMaintenance(req) {
+		r.writeMaintenance(rw)
+		return
+	}
+	if r.isPreflight(req) {
+		r.writePreflight(rw)
+		return
+	}
+	if r.isSleeping() {
+		r.writeSleeping(rw)
+		return
+	}
+	if isUpgrade(req) || r.bypasses(req) {
+		r.next.ServeHTTP(rw, req)
+		return
+	}
+	if r.fastPath && r.currentPolicies().singleAttempt() {
+		r.serveFast(rw, req)
+		return
+	}
+	if r.collapses(req) {
+		r.serveCollapsed(rw, req)
+		return
+	}
+	r.serveRetried(rw, req)
+}
+
+// serveRetried serves req, retrying it as configured.
+func (r *Retry) serveRetried(rw http.ResponseWriter, req *http.Request) {
+	start := r.clock.Now()
+	req = r.withRequestID(req)
+	req = r.withAttemptBudget(req)
+
+	ctx, span := r.tracer.Start(req.Context(), r.name)
+	defer span.End()
+	req = req.WithContext(ctx)
+
+	p := r.policyFor(req)
+	res := r.serve(rw, req, p)
+	r.transform(res.sw)
+	span.SetAttribute("attempts", res.attempts)
+	span.SetAttribute("http.status_code", res.sw.StatusCode())
+	r.metrics.observe(res.attempts, res.exhausted)
+	if r.forTest {
+		atomic.StoreInt64(&r.attemptsMade, int64(res.attempts))
+	}
+	r.setResultHeaders(res)
+	r.smooth(req, res.sw, start)
+	res.sw.flush(rw)
+	duration := r.clock.Now().Sub(start)
+	outcome := r.outcome(p, res)
+	if res.attempts > 0 {
+		r.emit(req, res.attempts, res.sw.StatusCode(), outcome)
+	}
+	r.logAccess(req, res.sw, duration, outcome)
+	r.warnSlow(req, res.sw, duration, res.attempts)
+}
+
+// setResultHeaders sets the configured headers describing how the request was served on its response,
+// when it was forwarded.
+func (r *Retry) setResultHeaders(res result) {
+	if res.attempts == 0 {
+		return
+	}
+	if r.retryCountHeader != "" {
+		res.sw.Header().Set(r.retryCountHeader, strconv.Itoa(res.attempts))
+	}
+	if r.byteCountHeader != "" && !res.sw.committed {
+		res.sw.Header().Set(r.byteCountHeader, strconv.Itoa(res.sw.length))
+	}
+	if r.warmStateHeader != "" {
+		res.sw.Header().Set(r.warmStateHeader, warmState(res.cold))
+	}
+	if r.serverTiming {
+		res.sw.Header().Set("Server-Timing", res.timing.header())
+	}
+}
+
+// result is the outcome of serving a request.
+type result struct {
+	// sw is the response to send to the client.
+	sw *statusWriter
+	// attempts is the number of attempts made.
+	attempts int
+	// exhausted is set when the last attempt failed and could not be retried, whether or not a retry was made.
+	exhausted bool
+	// cold is set when the request waited for the backend to wake up.
+	cold   bool
+	timing timing
+}
+
+// serve forwards req to the next handler, retrying as configured by p.
+func (r *Retry) serve(rw http.ResponseWriter, req *http.Request, p *policy) result {
+	client := req.Context()
+	if r.ctx.Err() != nil {
+		return result{sw: unavailable()}
+	}
+	if err := r.acquire(client); err != nil {
+		if errors.Is(err, errFull) {
+			return result{sw: unavailable()}
+		}
+		return result{sw: r.interrupted(req, client)}
+	}
+	defer r.release()
+
+	if sw := r.serveIdempotent(req); sw != nil {
+		return result{sw: sw}
+	}
+	if sw := r.serveStale(req, p); sw != nil {
+		return result{sw: sw}
+	}
+	if sw := r.hold(req, p); sw != nil {
+		return result{sw: sw}
+	}
+	wakeStart := r.clock.Now()
+	cold, err := r.awaken(req, p)
+	sleep := r.clock.Now().Sub(wakeStart)
+	if err != nil {
+		if errors.Is(err, errUnhealthy) || errors.Is(err, errSleepSaturated) {
+			return result{sw: unavailable()}
+		}
+		return result{sw: r.interrupted(req, client)}
+	}
+
+	attempts := r.attemptsFor(req, p)
+	if r.memory.full() {
+		attempts = 1
+	}
+	body, attempts, err := r.bufferBody(req, attempts)
+	if err != nil {
+		return result{sw: bodyError(err)}
+	}
+	if !r.circuit.allow(r.clock.Now()) {
+		return result{sw: unavailable()}
+	}
+	// The outcome is recorded even when the next handler panics, as a failure,
+	// not to leave the trial of a half-open circuit pending.
+	failed := true
+	defer func() {
+		r.circuit.record(failed, r.clock.Now())
+	}()
+	// The reservation is released on every return, a panic of the next handler included.
+	memory := r.memory.reservation()
+	defer memory.release()
+	if !memory.reserve(len(body)) {
+		attempts = 1
+	}
+
+	setForwardedHeaders(req)
+	res := r.retry(rw, req, p, body, attempts, memory)
+	res.cold = cold
+	res.timing.sleep = sleep
+	failed = r.failed(p, res.sw)
+	r.adaptive.record(failed)
+	if !failed {
+		r.markWarm(p.backend, r.clock.Now())
+		r.budget.reset(clientIP(req))
+		r.storeStale(req, res.sw)
+		r.storeIdempotent(req, res.sw)
+	}
+
+	if res.exhausted {
+		res.sw = r.exhaustedResponse(rw, req, body, res.sw, res.attempts)
+	}
+	return res
+}
+
+// retry forwards req to the next handler up to the given number of attempts,
+// replaying body on each of them.
+func (r *Retry) retry(rw http.ResponseWriter, req *http.Request, p *policy, body []byte, attempts int, memory *reservation) (res result) {
+	var t timing
+	defer func() {
+		res.timing = t
+	}()
+
+	client := req.Context()
+	if timeout := r.timeoutFor(req); timeout > 0 {
+		ctx, cancel := context.WithTimeout(req.Context(), timeout)
+		defer cancel()
+		req = req.WithContext(ctx)
+	}
+
+	r.ratio.request(r.clock.Now())
+
+	var sw *statusWriter
+	var counts map[int]int
+	if r.statusAttempts != nil {
+		counts = make(map[int]int)
+	}
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 {
+			backoffStart := r.clock.Now()
+			err := r.sleepRequest(req.Context(), r.retryDelay(p, attempt, sw))
+			t.backoff += r.clock.Now().Sub(backoffStart)
+			if errors.Is(err, errSleepSaturated) {
+				return result{sw: unavailable(), attempts: attempt - 1}
+			}
+			if err != nil {
+				return result{sw: r.interrupted(req, client), attempts: attempt - 1}
+			}
+			r.log.infof("retrying request %v (attempt %d, status %d)", req.URL, attempt, sw.status)
+			r.emit(req, attempt-1, sw.status, outcomeRetry)
+			r.listener.Retried(req, attempt)
+		}
+
+		canRetry := attempt < attempts
+		sw = newAttemptWriter(rw, r.streamingContentTypes, func(sw *statusWriter) bool {
+			// gRPC responses are kept until the end, their trailers being written after the body.
+			return r.grpcMode || canRetry && r.shouldRetry(p, sw, req, attempt+1)
+		})
+		sw.maxBufferBytes = r.maxResponseBufferBytes
+		sw.head = req.Method == http.MethodHead
+		sw.compressThreshold = r.compressThreshold
+		sw.memory = memory
+		sw.stripHeaders = r.stripResponseHeaders
+		backendStart := r.clock.Now()
+		buffered := sw
+		sw = r.forward(sw, req, body, attempt)
+		t.backend += r.clock.Now().Sub(backendStart)
+		if sw.hijacked || sw.committed || !r.shouldRetry(p, sw, req, attempt+1) {
+			return result{sw: sw, attempts: attempt}
+		}
+		if !canRetry || r.statusExhausted(counts, p, sw.status) {
+			return result{sw: sw, attempts: attempt, exhausted: true}
+		}
+		if r.dryRun {
+			r.log.infof("would retry request %v (attempt %d, status %d)", req.URL, attempt+1, sw.status)
+			return result{sw: sw, attempts: attempt}
+		}
+		// A failed attempt refused a retry by one of the limits is the last one, as when it was the last allowed.
+		if !r.chance() {
+			return result{sw: sw, attempts: attempt, exhausted: true}
+		}
+		if !r.allowRetry(req) {
+			return result{sw: sw, attempts: attempt, exhausted: true}
+		}
+		if req.Context().Err() != nil {
+			return result{sw: r.interrupted(req, client), attempts: attempt}
+		}
+		buffered.releaseMemory()
+	}
+}
+
+// allowRetry reports whether the per IP budget, the retry ratio and the shared attempts all allow a retry of req,
+// counting it in each of them if so. A retry refused by one of them is given back to the ones that counted it.
+func (r *Retry) allowRetry(req *http.Request) bool {
+	now := r.clock.Now()
+	client := clientIP(req)
+	if !r.budget.allow(client, now) {
+		return false
+	}
+	if !r.ratio.allow(now) {
+		r.budget.refund(client)
+		return false
+	}
+	if !takeRetry(req.Context()) {
+		r.ratio.refund(now)
+		r.budget.refund(client)
+		return false
+	}
+	return true
+}
+
+// forward makes a single attempt, in its own span, with a clone of req replaying body,
+// so that the changes made to the request by an attempt do not leak into the next one.
+func (r *Retry) forward(sw *statusWriter, req *http.Request, body []byte, attempt int) *statusWriter {
+	ctx, span := r.tracer.Start(req.Context(), "attempt")
+	defer span.End()
+	span.SetAttribute("attempt", attempt)
+
+	attemptCtx, cancel := r.attemptContext(ctx, attempt)
+	defer cancel()
+	req = req.Clone(context.WithValue(attemptCtx, attemptKey{}, attempt))
+	resetBody(req, body)
+	if !r.preserveHost && req.URL.Host != "" {
+		req.Host = req.URL.Host
+	}
+	for _, key := range r.stripRequestHeaders {
+		req.Header.Del(key)
+	}
+	if r.attemptHeader != "" {
+		req.Header.Set(r.attemptHeader, strconv.Itoa(attempt))
+	}
+	if r.preAttempt != nil {
+		r.preAttempt(req, attempt)
+	}
+
+	start := r.clock.Now()
+	sw = r.serveNext(sw, req, ctx, r.attemptTimeout(attempt))
+	r.latency.observe(r.clock.Now().Sub(start))
+	r.log.debugf("request %v attempt %d: status %d after %v", req.URL, attempt, sw.StatusCode(), r.clock.Now().Sub(start))
+	if timedOut(sw, ctx, attemptCtx) {
+		r.log.warnf("request %v attempt %d timed out after %v", req.URL, attempt, r.attemptTimeout(attempt))
+		sw = gatewayTimeout()
+	}
+	if sw.writeErr != nil {
+		r.log.warnf("request %v attempt %d: write to the client failed: %v", req.URL, attempt, sw.writeErr)
+		if !sw.sent {
+			sw = badGateway()
+		}
+	}
+
+	span.SetAttribute("http.status_code", sw.StatusCode())
+	return sw
+}
+
+// exhaustedResponse returns the response sent when all the given attempts failed, last being the last attempt:
+// the response of the first fallback backend that did not fail if any,
+// or the standardized error or the configured retries exhausted response.
+func (r *Retry) exhaustedResponse(rw http.ResponseWriter, req *http.Request, body []byte, last *statusWriter, attempts int) *statusWriter {
+	if len(r.fallbackURLs) > 0 {
+		sw, err := r.fallbacks(rw, req, body)
+		if err == nil {
+			return sw
+		}
+		r.log.errorf("fallback for request %v failed: %v", req.URL, err)
+	}
+	if r.errorContractStatus != 0 {
+		return r.errorContract(req, last, attempts)
+	}
+	if r.retriesExhaustedStatus != 0 {
+		return r.retriesExhausted()
+	}
+	return last
+}
+
+// retriesExhausted returns the response used in place of the last failed attempt when all of them failed.
+func (r *Retry) retriesExhausted() *statusWriter {
+	sw := newStatusWriter()
+	sw.WriteHeader(r.retriesExhaustedStatus)
+	if r.retriesExhaustedBody != "" {
+		sw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = sw.Write([]byte(r.retriesExhaustedBody))
+	}
+	return sw
+}
+
+// writeError responds with status and a JSON body holding message.
+func writeError(rw http.ResponseWriter, status int, message string) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	_ = json.NewEncoder(rw).Encode(struct {
+		Error string `json:"error"`
+	}{Error: message})
+}
+
+// isUpgrade reports whether req asks for a connection upgrade, such as a WebSocket.
+func isUpgrade(req *http.Request) bool {
+	for _, value := range req.Header["Connection"] {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// bodyError returns the response used when the request body could not be buffered.
+func bodyError(err error) *statusWriter {
+	sw := newStatusWriter()
+	if errors.Is(err, errBodyTooLarge) {
+		sw.WriteHeader(http.StatusRequestEntityTooLarge)
+	} else {
+		sw.WriteHeader(http.StatusBadRequest)
+	}
+	return sw
+}
+
+// unavailable returns the response used when the backend could not be woken up,
+// or when the plugin is closed.
+func unavailable() *statusWriter {
+	sw := newStatusWriter()
+	sw.WriteHeader(http.StatusServiceUnavailable)
+	return sw
+}
+
+// interrupted returns the response used when req is stopped before a final response:
+// a service unavailable when the plugin was closed, a client closed request when the client canceled it,
+// and a gateway timeout when a deadline expired.
+func (r *Retry) interrupted(req *http.Request, client context.Context) *statusWriter {
+	sw := newStatusWriter()
+	switch {
+	case r.ctx.Err() != nil:
+		r.log.infof("request %v interrupted: plugin closed", req.URL)
+		sw.WriteHeader(http.StatusServiceUnavailable)
+	case errors.Is(client.Err(), context.Canceled):
+		r.log.infof("request %v interrupted: canceled by the client", req.URL)
+		sw.WriteHeader(statusClientClosedRequest)
+	default:
+		r.log.infof("request %v interrupted: deadline exceeded", req.URL)
+		sw.WriteHeader(http.StatusGatewayTimeout)
+	}
+	return sw
+}
+
+// durationOption is a duration option of the configuration, parsed into target.
+type durationOption struct {
+	name   string
+	value  string
+	target *time.Duration
+}
+
+// durationOptions returns the duration options of config, parsed into the fields of r.
+func (r *Retry) durationOptions(config *Config) []durationOption {
+	return []durationOption{
+		{name: "timeout", value: config.Timeout, target: &r.timeout},
+		{name: "first byte timeout", value: config.FirstByteTimeout, target: &r.firstByteTimeout},
+		{name: "health check interval", value: config.HealthCheckInterval, target: &r.healthCheckInterval},
+		{name: "health check timeout", value: config.HealthCheckTimeout, target: &r.healthCheckTimeout},
+		{name: "health check jitter", value: config.HealthCheckJitter, target: &r.healthCheckJitter},
+		{name: "keep warm interval", value: config.KeepWarmInterval, target: &r.keepWarmInterval},
+		{name: "wake cache TTL", value: config.WakeCacheTTL, target: &r.wakeCacheTTL},
+		{name: "max retry after", value: config.MaxRetryAfter, target: &r.maxRetryAfter},
+		{name: "max delay", value: config.MaxDelay, target: &r.maxDelay},
+		{name: "max deadline", value: config.MaxDeadline, target: &r.maxDeadline},
+		{name: "min response time", value: config.MinResponseTime, target: &r.minResponseTime},
+		{name: "slow request threshold", value: config.SlowRequestThreshold, target: &r.slowRequestThreshold},
+	}
+}
+
+// parseDurations parses the duration options of config.
+func (r *Retry) parseDurations(config *Config) error {
+	for _, option := range r.durationOptions(config) {
+		d, err := parseDuration(option.name, option.value)
+		if err != nil {
+			return err
+		}
+		*option.target = d
+	}
+	return nil
+}
+
+// parseDuration parses the value of the named duration option, an empty value meaning zero.
+func parseDuration(name, value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("incorrect value for %s (%s): %w", name, value, err)
+	}
+	return d, nil
 }
@@ -4,9 +4,12 @@ import (
 	"context"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
-	"github.com/containous/plugindemo"
+	"github.com/madshargreave/traefik-sleep"
 )
 
 func TestDemo(t *testing.T) {
@@ -14,7 +17,86 @@ func TestDemo(t *testing.T) {
 	cfg.Attempts = 1
 
 	ctx := context.Background()
-	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("X-Demo", "test")
+	})
+
+	handler, err := plugindemo.New(ctx, next, cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler.ServeHTTP(recorder, req)
+
+	assertStatus(t, recorder, http.StatusOK)
+	assertHeader(t, recorder.Header(), "X-Demo", "test")
+}
+
+func TestRetry(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 3
+
+	ctx := context.Background()
+	calls := 0
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		if calls < 3 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	handler, err := plugindemo.New(ctx, next, cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler.ServeHTTP(recorder, req)
+
+	assertStatus(t, recorder, http.StatusOK)
+	if calls != 3 {
+		t.Errorf("invalid number of attempts: %d", calls)
+	}
+}
+
+func TestNilNext(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 1
+
+	recorder := serve(t, cfg, nil, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+	assertStatus(t, recorder, http.StatusInternalServerError)
+	assertHeader(t, recorder.Header(), "Content-Type", "application/json")
+	if body := strings.TrimSpace(recorder.Body.String()); body != `{"error":"no next handler"}` {
+		t.Errorf("invalid body: %q", body)
+	}
+}
+
+func TestRetryExhausted(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 2
+
+	ctx := context.Background()
+	calls := 0
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		rw.WriteHeader(http.StatusBadGateway)
+	})
 
 	handler, err := plugindemo.New(ctx, next, cfg, "demo-plugin")
 	if err != nil {
@@ -30,16 +112,805 @@ func TestDemo(t *testing.T) {
 
 	handler.ServeHTTP(recorder, req)
 
-	assertHeader(t, req, "X-Host", "localhost")
-	assertHeader(t, req, "X-URL", "http://localhost")
-	assertHeader(t, req, "X-Method", "GET")
-	assertHeader(t, req, "X-Demo", "test")
+	assertStatus(t, recorder, http.StatusBadGateway)
+	if calls != 2 {
+		t.Errorf("invalid number of attempts: %d", calls)
+	}
+}
+
+func TestAttemptsValidation(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		attempts    int
+		maxAttempts int
+		valid       bool
+	}{
+		{desc: "zero", attempts: 0, valid: false},
+		{desc: "negative", attempts: -1, valid: false},
+		{desc: "default maximum", attempts: 10, valid: true},
+		{desc: "above default maximum", attempts: 11, valid: false},
+		{desc: "custom maximum", attempts: 20, maxAttempts: 20, valid: true},
+		{desc: "above custom maximum", attempts: 21, maxAttempts: 20, valid: false},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			cfg := plugindemo.CreateConfig()
+			cfg.Attempts = test.attempts
+			if test.maxAttempts != 0 {
+				cfg.MaxAttempts = test.maxAttempts
+			}
+
+			_, err := plugindemo.New(context.Background(), http.NotFoundHandler(), cfg, "demo-plugin")
+			if test.valid && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if !test.valid && err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestRetriesExhaustedStatus(t *testing.T) {
+	testCases := []struct {
+		desc           string
+		attempts       int
+		failures       int
+		expectedStatus int
+		expectedBody   string
+	}{
+		{desc: "all attempts failed", attempts: 3, failures: 3, expectedStatus: http.StatusBadGateway, expectedBody: "backend unavailable"},
+		{desc: "last attempt succeeded", attempts: 3, failures: 2, expectedStatus: http.StatusOK, expectedBody: "ok"},
+		{desc: "single attempt failed", attempts: 1, failures: 1, expectedStatus: http.StatusBadGateway, expectedBody: "backend unavailable"},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			cfg := plugindemo.CreateConfig()
+			cfg.Attempts = test.attempts
+			cfg.RetriesExhaustedStatus = http.StatusBadGateway
+			cfg.RetriesExhaustedBody = "backend unavailable"
+
+			calls := 0
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				if calls <= test.failures {
+					rw.WriteHeader(http.StatusServiceUnavailable)
+					_, _ = rw.Write([]byte("unavailable"))
+					return
+				}
+				_, _ = rw.Write([]byte("ok"))
+			})
+
+			recorder := serve(t, cfg, next, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+			assertStatus(t, recorder, test.expectedStatus)
+			if recorder.Body.String() != test.expectedBody {
+				t.Errorf("invalid body: %q", recorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestRetryBuffersFailedAttempt(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 2
+
+	ctx := context.Background()
+	recorder := httptest.NewRecorder()
+	calls := 0
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		if calls == 1 {
+			rw.Header().Set("X-Attempt", "failed")
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = rw.Write([]byte("unavailable"))
+			return
+		}
+
+		if recorder.Body.Len() != 0 || len(recorder.Header()) != 0 {
+			t.Errorf("failed attempt leaked to the client: %q %v", recorder.Body.String(), recorder.Header())
+		}
+		_, _ = rw.Write([]byte("ok"))
+	})
+
+	handler, err := plugindemo.New(ctx, next, cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler.ServeHTTP(recorder, req)
+
+	assertStatus(t, recorder, http.StatusOK)
+	assertHeader(t, recorder.Header(), "X-Attempt", "")
+	if recorder.Body.String() != "ok" {
+		t.Errorf("invalid body: %q", recorder.Body.String())
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	output := captureLog(t)
+
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 3
+	cfg.DryRun = true
+
+	calls := 0
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	recorder := serve(t, cfg, next, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+	assertStatus(t, recorder, http.StatusServiceUnavailable)
+	if calls != 1 {
+		t.Errorf("invalid number of attempts: %d", calls)
+	}
+	if !strings.Contains(output.String(), "would retry request http://localhost (attempt 2, status 503)") {
+		t.Errorf("invalid log: %q", output.String())
+	}
+}
+
+func TestDelay(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 1
+	cfg.Delay = "50ms"
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+
+	start := time.Now()
+	recorder := serve(t, cfg, next, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+	assertStatus(t, recorder, http.StatusOK)
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("request was forwarded before the delay: %v", elapsed)
+	}
+}
+
+func TestDelayCanceled(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 1
+	cfg.Delay = "1m"
+
+	called := false
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		called = true
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	start := time.Now()
+	recorder := serve(t, cfg, next, httptest.NewRequest(http.MethodGet, "http://localhost", nil).WithContext(ctx))
+
+	assertStatus(t, recorder, 499)
+	if called {
+		t.Error("request was forwarded after the client went away")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("delay was not interrupted: %v", elapsed)
+	}
+}
+
+func TestInvalidDelay(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 1
+	cfg.Delay = "soon"
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+
+	if _, err := plugindemo.New(context.Background(), next, cfg, "demo-plugin"); err == nil {
+		t.Error("expected an error for an invalid delay")
+	}
+}
+
+func TestParseDelay(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected time.Duration
+	}{
+		{value: "1s", expected: time.Second},
+		{value: "500ms", expected: 500 * time.Millisecond},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.value, func(t *testing.T) {
+			cfg := plugindemo.CreateConfig()
+			cfg.Attempts = 1
+			cfg.Delay = test.value
+
+			handler, err := plugindemo.New(context.Background(), http.NotFoundHandler(), cfg, "demo-plugin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			clock := plugindemo.NewFakeClock(time.Now())
+			handler.(*plugindemo.Retry).SetClock(clock)
+
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+			if sleeps := clock.Sleeps(); len(sleeps) != 1 || sleeps[0] != test.expected {
+				t.Errorf("invalid sleeps: %v, expected %v", sleeps, test.expected)
+			}
+		})
+	}
+}
+
+func TestInvalidDurations(t *testing.T) {
+	tests := []struct {
+		name  string
+		apply func(cfg *plugindemo.Config)
+	}{
+		{name: "delay", apply: func(cfg *plugindemo.Config) { cfg.Delay = "abc" }},
+		{name: "timeout", apply: func(cfg *plugindemo.Config) { cfg.Timeout = "abc" }},
+		{name: "backoff base", apply: func(cfg *plugindemo.Config) { cfg.BackoffBase = "abc" }},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			cfg := plugindemo.CreateConfig()
+			cfg.Attempts = 1
+			test.apply(cfg)
+
+			_, err := plugindemo.New(context.Background(), http.NotFoundHandler(), cfg, "demo-plugin")
+			if err == nil {
+				t.Fatal("expected an error for an invalid duration")
+			}
+			if !strings.Contains(err.Error(), test.name) || !strings.Contains(err.Error(), "abc") {
+				t.Errorf("error does not name the option and its value: %v", err)
+			}
+		})
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 3
+	cfg.Timeout = "50ms"
+
+	calls := 0
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		time.Sleep(100 * time.Millisecond)
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	recorder := serve(t, cfg, next, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+	assertStatus(t, recorder, http.StatusGatewayTimeout)
+	if calls != 1 {
+		t.Errorf("invalid number of attempts: %d", calls)
+	}
+}
+
+func TestDeadlineHeader(t *testing.T) {
+	tests := []struct {
+		desc        string
+		timeout     string
+		maxDeadline string
+		deadline    string
+		expected    int
+		calls       int
+	}{
+		{desc: "valid", timeout: "10s", deadline: "50", expected: http.StatusGatewayTimeout, calls: 1},
+		{desc: "oversized", maxDeadline: "50ms", deadline: "60000", expected: http.StatusGatewayTimeout, calls: 1},
+		{desc: "missing", timeout: "50ms", expected: http.StatusGatewayTimeout, calls: 1},
+		{desc: "invalid", timeout: "50ms", deadline: "abc", expected: http.StatusGatewayTimeout, calls: 1},
+		{desc: "no timeout", deadline: "", expected: http.StatusServiceUnavailable, calls: 3},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			cfg := plugindemo.CreateConfig()
+			cfg.Attempts = 3
+			cfg.Timeout = test.timeout
+			cfg.DeadlineHeader = "X-Deadline-Ms"
+			if test.maxDeadline != "" {
+				cfg.MaxDeadline = test.maxDeadline
+			}
+
+			calls := 0
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				time.Sleep(100 * time.Millisecond)
+				rw.WriteHeader(http.StatusServiceUnavailable)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+			if test.deadline != "" {
+				req.Header.Set("X-Deadline-Ms", test.deadline)
+			}
+			recorder := serve(t, cfg, next, req)
+
+			assertStatus(t, recorder, test.expected)
+			if calls != test.calls {
+				t.Errorf("invalid number of attempts: %d", calls)
+			}
+		})
+	}
+}
+
+func TestAttemptTimeouts(t *testing.T) {
+	tests := []struct {
+		desc string
+		// slowFrom is the first attempt taking longer than the timeout of the later attempts.
+		slowFrom int
+		expected int
+		calls    int
+	}{
+		{desc: "slow first attempt", slowFrom: 1, expected: http.StatusOK, calls: 1},
+		{desc: "slow later attempts", slowFrom: 2, expected: http.StatusGatewayTimeout, calls: 3},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			captureLog(t)
+
+			cfg := plugindemo.CreateConfig()
+			cfg.Attempts = 3
+			cfg.AttemptTimeouts = []string{"500ms", "20ms"}
+
+			calls := 0
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				if calls < test.slowFrom {
+					rw.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				select {
+				case <-time.After(100 * time.Millisecond):
+				case <-req.Context().Done():
+				}
+			})
+
+			recorder := serve(t, cfg, next, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+			assertStatus(t, recorder, test.expected)
+			if calls != test.calls {
+				t.Errorf("invalid number of attempts: %d", calls)
+			}
+		})
+	}
+}
+
+func TestAttemptTimeoutAbandonsHandler(t *testing.T) {
+	captureLog(t)
+
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 2
+	cfg.AttemptTimeouts = []string{"20ms"}
+
+	var calls int32
+	lateWrite := make(chan error, 1)
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			// The handler ignores the context of the attempt.
+			rw.Header().Set("X-Attempt", "slow")
+			_, _ = rw.Write([]byte("partial "))
+			time.Sleep(200 * time.Millisecond)
+			_, err := rw.Write([]byte("too late"))
+			lateWrite <- err
+			return
+		}
+		_, _ = rw.Write([]byte("on time"))
+	})
+
+	start := time.Now()
+	recorder := serve(t, cfg, next, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+	elapsed := time.Since(start)
+
+	assertStatus(t, recorder, http.StatusOK)
+	if recorder.Body.String() != "on time" {
+		t.Errorf("invalid body: %q", recorder.Body.String())
+	}
+	if recorder.Header().Get("X-Attempt") != "" {
+		t.Error("header of the abandoned attempt sent")
+	}
+	if elapsed >= 200*time.Millisecond {
+		t.Errorf("retry waited for the abandoned attempt: %v", elapsed)
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("invalid number of attempts: %d", n)
+	}
+	if err := <-lateWrite; err == nil {
+		t.Error("late write of the abandoned attempt accepted")
+	}
+}
+
+func TestInvalidAttemptTimeouts(t *testing.T) {
+	for _, timeouts := range [][]string{{"abc"}, {"1s", "0s"}} {
+		cfg := plugindemo.CreateConfig()
+		cfg.Attempts = 3
+		cfg.AttemptTimeouts = timeouts
+
+		if _, err := plugindemo.New(context.Background(), http.NotFoundHandler(), cfg, "demo-plugin"); err == nil {
+			t.Errorf("expected an error for the attempt timeouts %q", timeouts)
+		}
+	}
+}
+
+func TestInterrupted(t *testing.T) {
+	tests := []struct {
+		desc     string
+		context  func() (context.Context, context.CancelFunc)
+		expected int
+		log      string
+	}{
+		{
+			desc: "canceled",
+			context: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				time.AfterFunc(20*time.Millisecond, cancel)
+				return ctx, cancel
+			},
+			expected: 499,
+			log:      "request http://localhost interrupted: canceled by the client",
+		},
+		{
+			desc: "deadline exceeded",
+			context: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 20*time.Millisecond)
+			},
+			expected: http.StatusGatewayTimeout,
+			log:      "request http://localhost interrupted: deadline exceeded",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			output := captureLog(t)
+
+			cfg := plugindemo.CreateConfig()
+			cfg.Attempts = 2
+			cfg.BackoffBase = "1m"
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				rw.WriteHeader(http.StatusServiceUnavailable)
+			})
+
+			ctx, cancel := test.context()
+			defer cancel()
+
+			recorder := serve(t, cfg, next, httptest.NewRequest(http.MethodGet, "http://localhost", nil).WithContext(ctx))
+
+			assertStatus(t, recorder, test.expected)
+			if !strings.Contains(output.String(), test.log) {
+				t.Errorf("invalid log: %q", output.String())
+			}
+		})
+	}
+}
+
+func TestTimeoutDuringBackoff(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 2
+	cfg.BackoffBase = "1m"
+	cfg.Timeout = "20ms"
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	start := time.Now()
+	recorder := serve(t, cfg, next, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+	assertStatus(t, recorder, http.StatusGatewayTimeout)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("backoff was not interrupted: %v", elapsed)
+	}
+}
+
+func TestAttemptHeader(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 3
+	cfg.AttemptHeader = "X-Retry-Attempt"
+
+	var values [][]string
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		values = append(values, req.Header.Values("X-Retry-Attempt"))
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	serve(t, cfg, next, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+	expected := []string{"1", "2", "3"}
+	if len(values) != len(expected) {
+		t.Fatalf("invalid number of attempts: %d", len(values))
+	}
+	for i, value := range values {
+		if len(value) != 1 || value[0] != expected[i] {
+			t.Errorf("attempt %d: invalid header values %v", i+1, value)
+		}
+	}
+}
+
+func TestRequestClonedPerAttempt(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 2
+
+	var values []string
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		values = append(values, req.Header.Get("X-Upstream"))
+		req.Header.Set("X-Upstream", "stale")
+		req.URL.Path = "/stale"
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	var paths []string
+	inspect := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		paths = append(paths, req.URL.Path)
+		next.ServeHTTP(rw, req)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/items", nil)
+	req.Header.Set("X-Upstream", "original")
+	serve(t, cfg, inspect, req)
+
+	if len(values) != 2 || values[1] != "original" {
+		t.Errorf("header mutation leaked into the next attempt: %v", values)
+	}
+	if len(paths) != 2 || paths[1] != "/items" {
+		t.Errorf("URL mutation leaked into the next attempt: %v", paths)
+	}
+}
+
+func TestPreserveHost(t *testing.T) {
+	tests := []struct {
+		desc         string
+		preserveHost bool
+		expected     string
+	}{
+		{desc: "preserved", preserveHost: true, expected: "client.example.com"},
+		{desc: "replaced by the URL host", preserveHost: false, expected: "backend.internal"},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			cfg := plugindemo.CreateConfig()
+			cfg.Attempts = 3
+			cfg.PreserveHost = test.preserveHost
+
+			var hosts []string
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				hosts = append(hosts, req.Host)
+				rw.WriteHeader(http.StatusServiceUnavailable)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "http://backend.internal/", nil)
+			req.Host = "client.example.com"
+			serve(t, cfg, next, req)
+
+			if len(hosts) != 3 {
+				t.Fatalf("invalid number of attempts: %d", len(hosts))
+			}
+			for i, host := range hosts {
+				if host != test.expected {
+					t.Errorf("attempt %d: invalid host %q", i+1, host)
+				}
+			}
+		})
+	}
+}
+
+func TestStripHeaders(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 2
+	cfg.StripRequestHeaders = []string{"X-Internal-Auth"}
+	cfg.StripResponseHeaders = []string{"x-backend-version"}
+
+	calls := 0
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		if req.Header.Get("X-Internal-Auth") != "" {
+			t.Errorf("stripped request header reached attempt %d", calls)
+		}
+		assertHeader(t, req.Header, "X-Kept", "kept")
+		rw.Header().Set("X-Backend-Version", "1.2.3")
+		if calls == 1 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.Header.Set("X-Internal-Auth", "secret")
+	req.Header.Set("X-Kept", "kept")
+	recorder := serve(t, cfg, next, req)
+
+	assertStatus(t, recorder, http.StatusOK)
+	if _, ok := recorder.Header()["X-Backend-Version"]; ok {
+		t.Errorf("stripped response header reached the client: %v", recorder.Header())
+	}
+	if calls != 2 {
+		t.Errorf("invalid number of attempts: %d", calls)
+	}
+}
+
+func TestRetryCountHeader(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 5
+	cfg.RetryCountHeader = "X-Retry-Count"
+
+	calls := 0
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		if calls < 3 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+
+	recorder := serve(t, cfg, next, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+	assertStatus(t, recorder, http.StatusOK)
+	assertHeader(t, recorder.Header(), "X-Retry-Count", "3")
+}
+
+func TestByteCountHeader(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 3
+	cfg.ByteCountHeader = "X-Byte-Count"
+
+	calls := 0
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		if calls < 2 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = rw.Write([]byte("backend is waking up"))
+			return
+		}
+		_, _ = rw.Write([]byte("ok"))
+	})
+
+	recorder := serve(t, cfg, next, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+	assertStatus(t, recorder, http.StatusOK)
+	if recorder.Body.String() != "ok" {
+		t.Fatalf("invalid body: %q", recorder.Body.String())
+	}
+	assertHeader(t, recorder.Header(), "X-Byte-Count", "2")
+}
+
+type recordingListener struct {
+	attempts []int
+}
+
+func (l *recordingListener) Retried(req *http.Request, attempt int) {
+	l.attempts = append(l.attempts, attempt)
+}
+
+func TestForwardedHeaders(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 3
+
+	var forwardedFor, forwardedProto []string
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		forwardedFor = append(forwardedFor, strings.Join(req.Header.Values("X-Forwarded-For"), "|"))
+		forwardedProto = append(forwardedProto, req.Header.Get("X-Forwarded-Proto"))
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.RemoteAddr = "192.0.2.10:4321"
+	serve(t, cfg, next, req)
+
+	if len(forwardedFor) != 3 {
+		t.Fatalf("invalid number of attempts: %d", len(forwardedFor))
+	}
+	for i := range forwardedFor {
+		if forwardedFor[i] != "192.0.2.10" {
+			t.Errorf("attempt %d: invalid X-Forwarded-For %q", i+1, forwardedFor[i])
+		}
+		if forwardedProto[i] != "http" {
+			t.Errorf("attempt %d: invalid X-Forwarded-Proto %q", i+1, forwardedProto[i])
+		}
+	}
+}
+
+func TestForwardedHeadersAppended(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 1
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		assertHeader(t, req.Header, "X-Forwarded-For", "203.0.113.1, 192.0.2.10")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.RemoteAddr = "192.0.2.10:4321"
+	req.Header.Set("X-Forwarded-For", "203.0.113.1")
+	serve(t, cfg, next, req)
+}
+
+func TestForwardedProtoKept(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 1
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		assertHeader(t, req.Header, "X-Forwarded-Proto", "https")
+	})
+
+	// The proxy in front terminated TLS.
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.RemoteAddr = "192.0.2.10:4321"
+	req.Header.Set("X-Forwarded-Proto", "https")
+	serve(t, cfg, next, req)
+}
+
+func TestListeners(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 3
+
+	ctx := context.Background()
+	calls := 0
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		if calls < 3 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+
+	first, second := &recordingListener{}, &recordingListener{}
+	handler, err := plugindemo.NewWithListeners(ctx, next, cfg, "demo-plugin", first, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+	for _, listener := range []*recordingListener{first, second} {
+		if len(listener.attempts) != 2 || listener.attempts[0] != 2 || listener.attempts[1] != 3 {
+			t.Errorf("invalid retry notifications: %v", listener.attempts)
+		}
+	}
+}
+
+// serve runs req against a new plugin built from cfg and next.
+func serve(t *testing.T, cfg *plugindemo.Config, next http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+	serveHandler(t, cfg, next, recorder, req)
+
+	return recorder
+}
+
+// serveHandler runs req against a new plugin built from cfg and next, writing the response to rw.
+func serveHandler(t *testing.T, cfg *plugindemo.Config, next http.Handler, rw http.ResponseWriter, req *http.Request) {
+	t.Helper()
+
+	handler, err := plugindemo.New(context.Background(), next, cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler.ServeHTTP(rw, req)
+}
+
+func assertStatus(t *testing.T, recorder *httptest.ResponseRecorder, expected int) {
+	t.Helper()
+
+	if recorder.Code != expected {
+		t.Errorf("invalid status code: %d", recorder.Code)
+	}
 }
 
-func assertHeader(t *testing.T, req *http.Request, key, expected string) {
+func assertHeader(t *testing.T, header http.Header, key, expected string) {
 	t.Helper()
 
-	if req.Header.Get(key) != expected {
-		t.Errorf("invalid header value: %s", req.Header.Get(key))
+	if header.Get(key) != expected {
+		t.Errorf("invalid header value: %s", header.Get(key))
 	}
 }
@@ -0,0 +1,32 @@
+package plugindemo
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// drainPollInterval is the wait between two checks of the requests still in flight while draining.
+const drainPollInterval = 10 * time.Millisecond
+
+// Drain makes the plugin answer the new requests with the draining status,
+// and waits for the requests in flight to complete, or until ctx is done in which case the context error is returned.
+// It is meant to be called on shutdown, such as when receiving a SIGTERM.
+func (r *Retry) Drain(ctx context.Context) error {
+	atomic.StoreInt32(&r.draining, 1)
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for atomic.LoadInt64(&r.inFlight) > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (r *Retry) isDraining() bool {
+	return atomic.LoadInt32(&r.draining) != 0
+}
@@ -0,0 +1,102 @@
+package plugindemo_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/madshargreave/traefik-sleep"
+)
+
+func TestDrain(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 1
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/slow" {
+			close(started)
+			<-release
+		}
+	})
+
+	handler, err := plugindemo.New(context.Background(), next, cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slow := httptest.NewRecorder()
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		handler.ServeHTTP(slow, httptest.NewRequest(http.MethodGet, "http://localhost/slow", nil))
+	}()
+	<-started
+
+	drained := make(chan error)
+	go func() {
+		drained <- handler.(*plugindemo.Retry).Drain(context.Background())
+	}()
+
+	// Wait for Drain to turn the draining on.
+	time.Sleep(20 * time.Millisecond)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+	assertStatus(t, recorder, http.StatusServiceUnavailable)
+
+	select {
+	case err := <-drained:
+		t.Fatalf("drain returned with a request in flight: %v", err)
+	default:
+	}
+
+	close(release)
+	<-served
+	assertStatus(t, slow, http.StatusOK)
+	if err := <-drained; err != nil {
+		t.Errorf("drain failed: %v", err)
+	}
+}
+
+func TestDrainTimeout(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 1
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	handler, err := plugindemo.New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		close(started)
+		<-release
+	}), cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := handler.(*plugindemo.Retry).Drain(ctx); err != context.DeadlineExceeded {
+		t.Errorf("invalid drain error: %v", err)
+	}
+}
+
+func TestDrainDefaultStatus(t *testing.T) {
+	// A configuration not made by CreateConfig answers the requests received once drained with a 503.
+	handler, err := plugindemo.New(context.Background(), http.NotFoundHandler(), &plugindemo.Config{Attempts: 1}, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := handler.(*plugindemo.Retry).Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+	assertStatus(t, recorder, http.StatusServiceUnavailable)
+}
@@ -0,0 +1,28 @@
+package plugindemo
+
+import (
+	"os"
+	"strconv"
+)
+
+// envPrefix prefixes the environment variables the configuration falls back to.
+const envPrefix = "TRAEFIK_SLEEP_"
+
+// withEnv returns a copy of config where the unset attempts, delay and timeout are read
+// from the TRAEFIK_SLEEP_ATTEMPTS, TRAEFIK_SLEEP_DELAY and TRAEFIK_SLEEP_TIMEOUT environment variables.
+// Invalid values are left to the validation of the configuration.
+func withEnv(config *Config) *Config {
+	c := *config
+	if c.Attempts == 0 {
+		if attempts, err := strconv.Atoi(os.Getenv(envPrefix + "ATTEMPTS")); err == nil {
+			c.Attempts = attempts
+		}
+	}
+	if c.Delay == "" {
+		c.Delay = os.Getenv(envPrefix + "DELAY")
+	}
+	if c.Timeout == "" {
+		c.Timeout = os.Getenv(envPrefix + "TIMEOUT")
+	}
+	return &c
+}
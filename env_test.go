@@ -0,0 +1,103 @@
+package plugindemo_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/madshargreave/traefik-sleep"
+)
+
+// setenv sets an environment variable for the duration of the test.
+func setenv(t *testing.T, key, value string) {
+	t.Helper()
+
+	if err := os.Setenv(key, value); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Unsetenv(key) })
+}
+
+func TestEnvAttempts(t *testing.T) {
+	setenv(t, "TRAEFIK_SLEEP_ATTEMPTS", "3")
+
+	calls := 0
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	serve(t, plugindemo.CreateConfig(), next, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+	if calls != 3 {
+		t.Errorf("invalid number of attempts: %d", calls)
+	}
+}
+
+func TestEnvAttemptsOverridden(t *testing.T) {
+	setenv(t, "TRAEFIK_SLEEP_ATTEMPTS", "3")
+
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 1
+
+	calls := 0
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	serve(t, cfg, next, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+	if calls != 1 {
+		t.Errorf("invalid number of attempts: %d", calls)
+	}
+}
+
+func TestEnvAttemptsInvalid(t *testing.T) {
+	for _, value := range []string{"", "three", "0"} {
+		value := value
+		t.Run(value, func(t *testing.T) {
+			if value != "" {
+				setenv(t, "TRAEFIK_SLEEP_ATTEMPTS", value)
+			}
+
+			if _, err := plugindemo.New(context.Background(), http.NotFoundHandler(), plugindemo.CreateConfig(), "demo-plugin"); err == nil {
+				t.Error("expected an error without a valid number of attempts")
+			}
+		})
+	}
+}
+
+func TestEnvDelay(t *testing.T) {
+	setenv(t, "TRAEFIK_SLEEP_DELAY", "2s")
+
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 1
+
+	handler, err := plugindemo.New(context.Background(), http.NotFoundHandler(), cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := plugindemo.NewFakeClock(time.Now())
+	handler.(*plugindemo.Retry).SetClock(clock)
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+	if sleeps := clock.Sleeps(); len(sleeps) != 1 || sleeps[0] != 2*time.Second {
+		t.Errorf("invalid sleeps: %v", sleeps)
+	}
+}
+
+func TestEnvTimeoutInvalid(t *testing.T) {
+	setenv(t, "TRAEFIK_SLEEP_TIMEOUT", "soon")
+
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 1
+
+	if _, err := plugindemo.New(context.Background(), http.NotFoundHandler(), cfg, "demo-plugin"); err == nil {
+		t.Error("expected an error for an invalid timeout")
+	}
+}
@@ -0,0 +1,39 @@
+package plugindemo
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// outcomeRetry is the outcome of an attempt followed by another one.
+const outcomeRetry = "retry"
+
+// RetryEvent describes the end of an attempt.
+type RetryEvent struct {
+	RequestID string
+	Attempt   int
+	Status    int
+	// Outcome is "retry" when the attempt is followed by another one,
+	// and the outcome of the request for its last attempt: "ok", "retried_ok" or "exhausted".
+	Outcome string
+}
+
+// Events returns the channel the retry events are sent to, nil when EventBufferSize is zero.
+// The events are dropped when the channel is full, and counted in the DroppedEvents metric.
+// The channel is never closed.
+func (r *Retry) Events() <-chan RetryEvent {
+	return r.events
+}
+
+// emit sends the event of an attempt of req, without waiting for the channel to have room.
+func (r *Retry) emit(req *http.Request, attempt, status int, outcome string) {
+	if r.events == nil {
+		return
+	}
+
+	select {
+	case r.events <- RetryEvent{RequestID: RequestID(req.Context()), Attempt: attempt, Status: status, Outcome: outcome}:
+	default:
+		atomic.AddInt64(&r.metrics.DroppedEvents, 1)
+	}
+}
@@ -0,0 +1,68 @@
+package plugindemo_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/madshargreave/traefik-sleep"
+)
+
+func TestEvents(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 3
+	cfg.EventBufferSize = 3
+
+	calls := 0
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		if calls%2 == 1 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+
+	handler, err := plugindemo.New(context.Background(), next, cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	retry := handler.(*plugindemo.Retry)
+
+	for _, id := range []string{"req-1", "req-2"} {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+		req.Header.Set("X-Request-Id", id)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// The buffer holds the 2 events of the first request and the first event of the second one.
+	var events []plugindemo.RetryEvent
+	for len(retry.Events()) > 0 {
+		events = append(events, <-retry.Events())
+	}
+	expected := []plugindemo.RetryEvent{
+		{RequestID: "req-1", Attempt: 1, Status: http.StatusServiceUnavailable, Outcome: "retry"},
+		{RequestID: "req-1", Attempt: 2, Status: http.StatusOK, Outcome: "retried_ok"},
+		{RequestID: "req-2", Attempt: 1, Status: http.StatusServiceUnavailable, Outcome: "retry"},
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("invalid events: %+v", events)
+	}
+	if dropped := retry.Metrics().DroppedEvents; dropped != 1 {
+		t.Errorf("invalid number of dropped events: %d", dropped)
+	}
+}
+
+func TestEventsDisabled(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 1
+
+	handler, err := plugindemo.New(context.Background(), http.NotFoundHandler(), cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if handler.(*plugindemo.Retry).Events() != nil {
+		t.Error("events channel created without an event buffer size")
+	}
+}
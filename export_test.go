@@ -0,0 +1,146 @@
+package plugindemo
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// NextBackoff exposes the backoff of the top-level policy to the tests.
+func (r *Retry) NextBackoff(attempt int) time.Duration {
+	return r.currentPolicies().base.backoff.next(attempt)
+}
+
+// RetryAfter exposes retryAfter to the tests.
+func RetryAfter(header http.Header, now time.Time) (time.Duration, bool) {
+	return retryAfter(header, now)
+}
+
+// MaxStaleEntries exposes the number of entries a stale or idempotency cache keeps at most to the tests.
+const MaxStaleEntries = maxStaleEntries
+
+// IdempotencyEntries returns the number of responses in the idempotency cache.
+func (r *Retry) IdempotencyEntries() int {
+	r.idempotency.mu.Lock()
+	defer r.idempotency.mu.Unlock()
+	return len(r.idempotency.entries)
+}
+
+// StaleEntries returns the number of responses in the stale cache.
+func (r *Retry) StaleEntries() int {
+	r.stale.mu.Lock()
+	defer r.stale.mu.Unlock()
+	return len(r.stale.entries)
+}
+
+// SetClock replaces the clock of the plugin.
+func (r *Retry) SetClock(c *FakeClock) {
+	r.clock = c
+}
+
+// NewWithClock creates a new Demo plugin using c from the start, for the background tasks started by New.
+func NewWithClock(ctx context.Context, next http.Handler, config *Config, name string, c *FakeClock) (http.Handler, error) {
+	r, err := newRetry(ctx, next, config, name, c, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	r.start()
+	return r, nil
+}
+
+// FakeClock is a clock whose time only moves when sleeping or when advanced explicitly.
+type FakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	sleeps []time.Duration
+
+	// blocking makes the sleeps wait for the time to be advanced past their end.
+	blocking bool
+	sleepers []*sleeper
+}
+
+type sleeper struct {
+	until time.Time
+	done  chan struct{}
+}
+
+// NewFakeClock returns a fake clock starting at now.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// NewBlockingFakeClock returns a fake clock starting at now, whose sleeps wait for Advance.
+func NewBlockingFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now, blocking: true}
+}
+
+// Now returns the current fake time.
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Sleep records the sleep and advances the fake time by d without waiting,
+// or waits for the time to be advanced by d when blocking.
+func (c *FakeClock) Sleep(ctx context.Context, d time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.sleeps = append(c.sleeps, d)
+	if !c.blocking {
+		c.now = c.now.Add(d)
+		c.mu.Unlock()
+		return nil
+	}
+	s := &sleeper{until: c.now.Add(d), done: make(chan struct{})}
+	c.sleepers = append(c.sleepers, s)
+	c.mu.Unlock()
+
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Advance moves the fake time forward by d, ending the blocking sleeps that are over.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+
+	sleepers := c.sleepers[:0]
+	for _, s := range c.sleepers {
+		if c.now.Before(s.until) {
+			sleepers = append(sleepers, s)
+		} else {
+			close(s.done)
+		}
+	}
+	c.sleepers = sleepers
+}
+
+// WaitSleepers waits until n blocking sleeps are in progress.
+func (c *FakeClock) WaitSleepers(n int) {
+	for {
+		c.mu.Lock()
+		count := len(c.sleepers)
+		c.mu.Unlock()
+		if count >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Sleeps returns the durations of all the sleeps so far.
+func (c *FakeClock) Sleeps() []time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]time.Duration(nil), c.sleeps...)
+}
@@ -0,0 +1,73 @@
+package plugindemo
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// fallbackURLs returns FallbackURL followed by FallbackURLs.
+func fallbackURLs(config *Config) []string {
+	var urls []string
+	if config.FallbackURL != "" {
+		urls = append(urls, config.FallbackURL)
+	}
+	return append(urls, config.FallbackURLs...)
+}
+
+// fallbacks sends req to each fallback URL in turn, replaying body, until one of them does not fail,
+// and returns the response streamed to rw, or the error of the last fallback.
+func (r *Retry) fallbacks(rw http.ResponseWriter, req *http.Request, body []byte) (*statusWriter, error) {
+	var err error
+	for _, target := range r.fallbackURLs {
+		var sw *statusWriter
+		if sw, err = r.fallback(rw, req, body, target); err == nil {
+			return sw, nil
+		}
+	}
+	return nil, err
+}
+
+// fallback sends req to the target fallback URL, replaying body,
+// and streams the response to rw through the returned committed statusWriter.
+// A 5xx response is not streamed, and is returned as an error.
+func (r *Retry) fallback(rw http.ResponseWriter, req *http.Request, body []byte, target string) (*statusWriter, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + req.URL.Path
+	u.RawQuery = req.URL.RawQuery
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	fallbackReq, err := http.NewRequestWithContext(req.Context(), req.Method, u.String(), reader)
+	if err != nil {
+		return nil, err
+	}
+	fallbackReq.Header = req.Header.Clone()
+
+	resp, err := r.client.Do(fallbackReq)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		return nil, fmt.Errorf("fallback %s responded with status %d", target, resp.StatusCode)
+	}
+
+	sw := newAttemptWriter(rw, nil, nil)
+	sw.stripHeaders = r.stripResponseHeaders
+	copyHeader(sw.Header(), resp.Header)
+	sw.WriteHeader(resp.StatusCode)
+	sw.commit()
+	_, _ = io.Copy(sw, resp.Body)
+	return sw, nil
+}
@@ -0,0 +1,174 @@
+package plugindemo_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/madshargreave/traefik-sleep"
+)
+
+func TestFallback(t *testing.T) {
+	fallback := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		if req.Method != http.MethodPut || req.URL.RequestURI() != "/items?id=1" || string(body) != "item" {
+			t.Errorf("invalid fallback request: %s %s %q", req.Method, req.URL.RequestURI(), body)
+		}
+		if req.Header.Get("X-Custom") != "value" {
+			t.Errorf("request headers not copied: %v", req.Header)
+		}
+		rw.Header().Set("X-Backend", "fallback")
+		_, _ = rw.Write([]byte("from fallback"))
+	}))
+	defer fallback.Close()
+
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 2
+	cfg.FallbackURL = fallback.URL
+
+	calls := 0
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	req := httptest.NewRequest(http.MethodPut, "http://localhost/items?id=1", strings.NewReader("item"))
+	req.Header.Set("X-Custom", "value")
+	recorder := serve(t, cfg, next, req)
+
+	assertStatus(t, recorder, http.StatusOK)
+	assertHeader(t, recorder.Header(), "X-Backend", "fallback")
+	if recorder.Body.String() != "from fallback" {
+		t.Errorf("invalid body: %q", recorder.Body.String())
+	}
+	if calls != 2 {
+		t.Errorf("invalid number of attempts: %d", calls)
+	}
+}
+
+func TestFallbackSingleAttempt(t *testing.T) {
+	fallback := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte("from fallback"))
+	}))
+	defer fallback.Close()
+
+	tests := []struct {
+		desc     string
+		attempts int
+		method   string
+	}{
+		{desc: "single attempt", attempts: 1, method: http.MethodGet},
+		{desc: "not idempotent", attempts: 3, method: http.MethodPost},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			cfg := plugindemo.CreateConfig()
+			cfg.Attempts = test.attempts
+			cfg.FallbackURL = fallback.URL
+
+			calls := 0
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				rw.WriteHeader(http.StatusServiceUnavailable)
+			})
+
+			// The request failed without being retried: it is sent to the fallback all the same.
+			recorder := serve(t, cfg, next, httptest.NewRequest(test.method, "http://localhost", nil))
+
+			assertStatus(t, recorder, http.StatusOK)
+			if recorder.Body.String() != "from fallback" {
+				t.Errorf("invalid body: %q", recorder.Body.String())
+			}
+			if calls != 1 {
+				t.Errorf("invalid number of attempts: %d", calls)
+			}
+		})
+	}
+}
+
+func TestFallbackUnreachable(t *testing.T) {
+	fallback := httptest.NewServer(http.NotFoundHandler())
+	fallback.Close()
+
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 2
+	cfg.FallbackURL = fallback.URL
+	cfg.RetriesExhaustedStatus = http.StatusBadGateway
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	recorder := serve(t, cfg, next, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+	assertStatus(t, recorder, http.StatusBadGateway)
+}
+
+func TestFallbackURLs(t *testing.T) {
+	var called []string
+	newFallback := func(name string, status int) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			called = append(called, name)
+			rw.WriteHeader(status)
+			_, _ = rw.Write([]byte("from " + name))
+		}))
+	}
+	first := newFallback("first", http.StatusBadGateway)
+	defer first.Close()
+	second := newFallback("second", http.StatusOK)
+	defer second.Close()
+	third := newFallback("third", http.StatusOK)
+	defer third.Close()
+
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 2
+	cfg.FallbackURLs = []string{first.URL, second.URL, third.URL}
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	recorder := serve(t, cfg, next, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+	assertStatus(t, recorder, http.StatusOK)
+	if recorder.Body.String() != "from second" {
+		t.Errorf("invalid body: %q", recorder.Body.String())
+	}
+	if strings.Join(called, ",") != "first,second" {
+		t.Errorf("invalid fallbacks called: %v", called)
+	}
+}
+
+func TestFallbackURLsFailing(t *testing.T) {
+	fallback := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer fallback.Close()
+
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 2
+	cfg.FallbackURLs = []string{fallback.URL, fallback.URL}
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	recorder := serve(t, cfg, next, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+	assertStatus(t, recorder, http.StatusServiceUnavailable)
+}
+
+func TestInvalidFallbackURL(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 1
+	cfg.FallbackURL = "localhost:8080"
+
+	if _, err := plugindemo.New(context.Background(), http.NotFoundHandler(), cfg, "demo-plugin"); err == nil {
+		t.Error("expected an error for an invalid fallback URL")
+	}
+}
@@ -0,0 +1,89 @@
+package plugindemo
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// hasFastPath reports whether the configuration leaves nothing for the middleware to do
+// beyond forwarding the requests once, so that they can skip buffering and the response wrapping.
+// The policies are checked separately since UpdateConfig can replace them.
+func (r *Retry) hasFastPath() bool {
+	return !r.accessLog &&
+		r.timeout == 0 &&
+		r.deadlineHeader == "" &&
+		r.minResponseTime == 0 &&
+		r.slowRequestThreshold == 0 &&
+		r.sharedAttempts == 0 &&
+		!r.serverTiming &&
+		!r.collapseRequests &&
+		r.stale == nil &&
+		r.idempotency == nil &&
+		r.events == nil &&
+		r.firstByteTimeout == 0 &&
+		len(r.attemptTimeouts) == 0 &&
+		r.statusAttempts == nil &&
+		r.healthCheckURL == "" &&
+		r.delayHeader == "" &&
+		r.circuit == nil &&
+		r.slots == nil &&
+		r.requestIDHeader == "" &&
+		r.retryCountHeader == "" &&
+		r.byteCountHeader == "" &&
+		r.warmStateHeader == "" &&
+		r.attemptHeader == "" &&
+		r.preserveHost &&
+		len(r.stripRequestHeaders) == 0 &&
+		len(r.stripResponseHeaders) == 0
+}
+
+// singleAttempt reports whether every policy makes a single attempt without any delay.
+func (ps *policies) singleAttempt() bool {
+	if !ps.base.direct() {
+		return false
+	}
+	for i := range ps.rules {
+		if !ps.rules[i].policy.direct() {
+			return false
+		}
+	}
+	for _, p := range ps.hosts {
+		if !p.direct() {
+			return false
+		}
+	}
+	for _, d := range ps.pathDelays {
+		if d.delay > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *policy) direct() bool {
+	return p.attempts == 1 && p.delay == 0 && p.healthCheckURL == ""
+}
+
+// serveFast forwards the request to the next handler, with the forwarded headers set, writing straight to rw.
+// A panic of the next handler is answered with a 500 as on the full path,
+// although the response may already have been partly sent.
+func (r *Retry) serveFast(rw http.ResponseWriter, req *http.Request) {
+	if r.ctx.Err() != nil {
+		unavailable().flush(rw)
+		return
+	}
+	setForwardedHeaders(req)
+	start := r.clock.Now()
+	recovered := r.callNext(rw, req)
+	r.latency.observe(r.clock.Now().Sub(start))
+	r.metrics.observe(1, false)
+	if recovered == nil {
+		return
+	}
+	if recovered == http.ErrAbortHandler {
+		panic(recovered)
+	}
+	r.log.errorf("recovered from panic serving request %v: %v", req.URL, recovered)
+	atomic.AddInt64(&r.metrics.Panics, 1)
+	rw.WriteHeader(http.StatusInternalServerError)
+}
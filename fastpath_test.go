@@ -0,0 +1,184 @@
+package plugindemo_test
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/madshargreave/traefik-sleep"
+)
+
+func fastPathConfig() *plugindemo.Config {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 1
+	cfg.AccessLog = false
+	cfg.RequestIDHeader = ""
+	return cfg
+}
+
+func TestFastPath(t *testing.T) {
+	captureLog(t)
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("X-Backend", "sleepy")
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = rw.Write([]byte("waking up"))
+	})
+
+	full := fastPathConfig()
+	full.AccessLog = true
+
+	expected := serve(t, full, next, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+	recorder := serve(t, fastPathConfig(), next, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+	assertStatus(t, recorder, expected.Code)
+	assertHeader(t, recorder.Header(), "X-Backend", expected.Header().Get("X-Backend"))
+	if recorder.Body.String() != expected.Body.String() {
+		t.Errorf("invalid body: got %q, want %q", recorder.Body.String(), expected.Body.String())
+	}
+}
+
+func TestFastPathForwardedHeaders(t *testing.T) {
+	var forwarded []http.Header
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		forwarded = append(forwarded, req.Header.Clone())
+	})
+
+	full := fastPathConfig()
+	full.AccessLog = true
+
+	for _, cfg := range []*plugindemo.Config{full, fastPathConfig()} {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+		req.Header.Set("X-Forwarded-For", "203.0.113.7")
+		serve(t, cfg, next, req)
+	}
+
+	if len(forwarded) != 2 {
+		t.Fatalf("invalid number of calls: %d", len(forwarded))
+	}
+	for _, key := range []string{"X-Forwarded-For", "X-Forwarded-Proto"} {
+		assertHeader(t, forwarded[1], key, forwarded[0].Get(key))
+	}
+	assertHeader(t, forwarded[1], "X-Forwarded-For", "203.0.113.7, 192.0.2.1")
+}
+
+func TestFastPathPanic(t *testing.T) {
+	output := captureLog(t)
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		panic("boom")
+	})
+
+	handler, err := plugindemo.New(context.Background(), next, fastPathConfig(), "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+	assertStatus(t, recorder, http.StatusInternalServerError)
+	if panics := handler.(*plugindemo.Retry).Metrics().Panics; panics != 1 {
+		t.Errorf("invalid number of panics: %d", panics)
+	}
+	if !strings.Contains(output.String(), "recovered from panic") {
+		t.Errorf("panic not logged: %q", output.String())
+	}
+}
+
+func TestFastPathAbortHandler(t *testing.T) {
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		panic(http.ErrAbortHandler)
+	})
+
+	handler, err := plugindemo.New(context.Background(), next, fastPathConfig(), "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		if recovered := recover(); recovered != http.ErrAbortHandler {
+			t.Errorf("invalid panic: %v", recovered)
+		}
+	}()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+}
+
+func TestFastPathAllocations(t *testing.T) {
+	handler, err := plugindemo.New(context.Background(), http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}), fastPathConfig(), "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	// Without a client address, the forwarded headers, set as on the full path, are left alone.
+	req.RemoteAddr = ""
+	if allocs := testing.AllocsPerRun(100, func() { handler.ServeHTTP(rw, req) }); allocs != 0 {
+		t.Errorf("invalid allocations: %v", allocs)
+	}
+}
+
+func TestFastPathUpdateConfig(t *testing.T) {
+	calls := 0
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	cfg := fastPathConfig()
+	handler, err := plugindemo.New(context.Background(), next, cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.Attempts = 2
+	if err := handler.(*plugindemo.Retry).UpdateConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+	if calls != 2 {
+		t.Errorf("invalid number of calls: %d", calls)
+	}
+}
+
+func BenchmarkServeHTTP(b *testing.B) {
+	writer := log.Writer()
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(writer)
+
+	full := fastPathConfig()
+	full.AccessLog = true
+	full.LogFormat = "json"
+
+	for _, bench := range []struct {
+		desc string
+		cfg  *plugindemo.Config
+	}{
+		{desc: "fast path", cfg: fastPathConfig()},
+		{desc: "full path", cfg: full},
+	} {
+		bench := bench
+		b.Run(bench.desc, func(b *testing.B) {
+			handler, err := plugindemo.New(context.Background(), http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}), bench.cfg, "demo-plugin")
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				// The full path appends the client address to the forwarded headers of the request.
+				req.Header.Del("X-Forwarded-For")
+				handler.ServeHTTP(rw, req)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package plugindemo_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/madshargreave/traefik-sleep"
+)
+
+func TestFirstByteTimeout(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 2
+	cfg.FirstByteTimeout = "20ms"
+
+	var calls int32
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			select {
+			case <-req.Context().Done():
+			case <-time.After(time.Second):
+			}
+			_, _ = rw.Write([]byte("too late"))
+			return
+		}
+		_, _ = rw.Write([]byte("on time"))
+	})
+
+	recorder := serve(t, cfg, next, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+	assertStatus(t, recorder, http.StatusOK)
+	if recorder.Body.String() != "on time" {
+		t.Errorf("invalid body: %q", recorder.Body.String())
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("invalid number of attempts: %d", n)
+	}
+}
+
+func TestFirstByteTimeoutExhausted(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 1
+	cfg.FirstByteTimeout = "20ms"
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		<-req.Context().Done()
+	})
+
+	recorder := serve(t, cfg, next, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+	assertStatus(t, recorder, http.StatusGatewayTimeout)
+}
+
+func TestFirstByteTimeoutSlowBody(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 2
+	cfg.FirstByteTimeout = "20ms"
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+		time.Sleep(50 * time.Millisecond)
+		_, _ = rw.Write([]byte("slow body"))
+	})
+
+	recorder := serve(t, cfg, next, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+	assertStatus(t, recorder, http.StatusOK)
+	if recorder.Body.String() != "slow body" {
+		t.Errorf("invalid body: %q", recorder.Body.String())
+	}
+}
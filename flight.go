@@ -0,0 +1,51 @@
+package plugindemo
+
+import (
+	"context"
+	"sync"
+)
+
+// flightGroup runs a single call at a time per key, the concurrent callers sharing its result.
+// It is a minimal golang.org/x/sync/singleflight, which plugins cannot depend on.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flight
+}
+
+type flight struct {
+	done chan struct{}
+	err  error
+}
+
+// do runs fn unless a call for key is in flight, and waits for the result of the call,
+// or until ctx is done in which case the context error is returned.
+// The call keeps going when the caller that started it goes away.
+func (g *flightGroup) do(ctx context.Context, key string, fn func() error) error {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flight)
+	}
+	f, ok := g.calls[key]
+	if !ok {
+		f = &flight{done: make(chan struct{})}
+		g.calls[key] = f
+		go g.run(key, f, fn)
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (g *flightGroup) run(key string, f *flight, fn func() error) {
+	f.err = fn()
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+	close(f.done)
+}
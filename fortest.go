@@ -0,0 +1,48 @@
+package plugindemo
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+type attemptKey struct{}
+
+// Attempt returns the number of the attempt whose request carries ctx, starting with 1, or 0 outside of an attempt.
+func Attempt(ctx context.Context) int {
+	attempt, _ := ctx.Value(attemptKey{}).(int)
+	return attempt
+}
+
+// NewForTest creates a plugin meant for the tests of the handlers it wraps, with next and cfg.
+// It panics when cfg is invalid.
+// Unlike New, it never waits for the delays and backoffs, so that the tests run deterministically and fast,
+// and records the number of attempts made for each request, returned by AttemptsMade.
+// It does not start the background tasks either: neither PrewarmOnStart nor KeepWarmInterval polls the health check URL.
+func NewForTest(next http.Handler, cfg *Config) *Retry {
+	r, err := newRetry(context.Background(), next, cfg, "test", instantClock{}, nil, nil)
+	if err != nil {
+		panic(err)
+	}
+	r.forTest = true
+	r.fastPath = false
+	return r
+}
+
+// AttemptsMade returns the number of attempts made for the last request served by a plugin created with NewForTest,
+// 0 while a request is being served or when it was not forwarded at all.
+func (r *Retry) AttemptsMade() int {
+	return int(atomic.LoadInt64(&r.attemptsMade))
+}
+
+// instantClock is a real clock whose sleeps return right away.
+type instantClock struct{}
+
+func (instantClock) Now() time.Time {
+	return time.Now()
+}
+
+func (instantClock) Sleep(ctx context.Context, d time.Duration) error {
+	return ctx.Err()
+}
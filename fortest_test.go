@@ -0,0 +1,100 @@
+package plugindemo_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/madshargreave/traefik-sleep"
+)
+
+func TestNewForTest(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 3
+	cfg.Delay = "1m"
+	cfg.BackoffBase = "1m"
+
+	// The handler under test succeeds on its second attempt.
+	var attempts []int
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		attempt := plugindemo.Attempt(req.Context())
+		attempts = append(attempts, attempt)
+		if attempt < 2 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+
+	retry := plugindemo.NewForTest(next, cfg)
+
+	start := time.Now()
+	recorder := httptest.NewRecorder()
+	retry.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("request waited for the delays: %v", elapsed)
+	}
+	assertStatus(t, recorder, http.StatusOK)
+	if got := retry.AttemptsMade(); got != 2 {
+		t.Errorf("invalid number of attempts made: %d", got)
+	}
+	if len(attempts) != 2 || attempts[0] != 1 || attempts[1] != 2 {
+		t.Errorf("invalid attempts seen by the handler: %v", attempts)
+	}
+	if got := retry.Metrics().Retries; got != 1 {
+		t.Errorf("invalid number of retries: %d", got)
+	}
+}
+
+func TestNewForTestNotForwarded(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 3
+
+	retry := plugindemo.NewForTest(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}), cfg)
+
+	retry.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+	if got := retry.AttemptsMade(); got != 3 {
+		t.Fatalf("invalid number of attempts made: %d", got)
+	}
+
+	retry.SetMaintenance(true)
+	retry.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+	if got := retry.AttemptsMade(); got != 0 {
+		t.Errorf("invalid number of attempts made for a request in maintenance: %d", got)
+	}
+}
+
+func TestNewForTestInvalidConfig(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for an invalid configuration")
+		}
+	}()
+
+	plugindemo.NewForTest(http.NotFoundHandler(), plugindemo.CreateConfig())
+}
+
+func TestNewForTestKeepWarm(t *testing.T) {
+	var polls int64
+	health := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt64(&polls, 1)
+	}))
+	defer health.Close()
+
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 1
+	cfg.HealthCheckURL = health.URL
+	cfg.PrewarmOnStart = true
+	cfg.KeepWarmInterval = "1h"
+
+	retry := plugindemo.NewForTest(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}), cfg)
+	defer retry.Close()
+
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt64(&polls); n != 0 {
+		t.Errorf("health check URL polled in the background: %d times", n)
+	}
+}
@@ -0,0 +1,28 @@
+package plugindemo
+
+import "net/http"
+
+// setForwardedHeaders adds the client to the X-Forwarded-For header of req and sets X-Forwarded-Proto,
+// unless a proxy in front, which may have terminated TLS, already set it.
+// It must be called once per request, before the first attempt, for the client not to be repeated on retries.
+// Outgoing client requests, which have no remote address, are left untouched.
+func setForwardedHeaders(req *http.Request) {
+	if req.RemoteAddr == "" {
+		return
+	}
+
+	forwardedFor := remoteIP(req)
+	if prior := req.Header.Get("X-Forwarded-For"); prior != "" {
+		forwardedFor = prior + ", " + forwardedFor
+	}
+	req.Header.Set("X-Forwarded-For", forwardedFor)
+
+	if req.Header.Get("X-Forwarded-Proto") != "" {
+		return
+	}
+	proto := "http"
+	if req.TLS != nil {
+		proto = "https"
+	}
+	req.Header.Set("X-Forwarded-Proto", proto)
+}
@@ -0,0 +1,147 @@
+package plugindemo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var errUnhealthy = errors.New("backend is not healthy")
+
+// validateURL checks that the value of the named URL option, if any, is an absolute HTTP(S) URL.
+func validateURL(name, raw string) error {
+	if raw == "" {
+		return nil
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("incorrect value for %s (%s): %w", name, raw, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("incorrect value for %s (%s)", name, raw)
+	}
+	return nil
+}
+
+// validateHeaderNames checks that the names of the named headers option are valid header field names.
+func validateHeaderNames(name string, headers map[string]string) error {
+	for key := range headers {
+		if key == "" || strings.IndexFunc(key, func(c rune) bool { return !isTokenChar(c) }) >= 0 {
+			return fmt.Errorf("incorrect header name for %s (%q)", name, key)
+		}
+	}
+	return nil
+}
+
+// isTokenChar reports whether c may appear in a header field name.
+func isTokenChar(c rune) bool {
+	return c < 0x7f && (c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' ||
+		strings.ContainsRune("!#$%&'*+-.^_`|~", c))
+}
+
+// wakeShared wakes the backend of p like wake, a single wake being made at a time per health check URL
+// for all the concurrent requests, which wait for its result or until ctx is done.
+// The wake itself is only interrupted when the plugin is closed.
+func (r *Retry) wakeShared(ctx context.Context, p *policy) error {
+	if p.healthCheckURL == "" {
+		return nil
+	}
+	return r.wakes.do(ctx, p.healthCheckURL, func() error {
+		return r.wake(r.ctx, p)
+	})
+}
+
+// wake polls the health check URL of p, up to the attempts of p,
+// until the backend reports itself as healthy.
+// The first poll is delayed by a random duration up to the health check jitter,
+// so that the requests waiting for the same backend do not poll it in lockstep.
+// The wake is interrupted when ctx is done or when the plugin is closed.
+// It waits for a wake slot first, when the concurrent wakes are limited.
+func (r *Retry) wake(ctx context.Context, p *policy) error {
+	if p.healthCheckURL == "" {
+		return nil
+	}
+	if err := r.acquireWake(ctx); err != nil {
+		return err
+	}
+	defer r.releaseWake()
+	if r.healthCheckJitter > 0 {
+		if err := r.sleep(ctx, time.Duration(randomFloat64()*float64(r.healthCheckJitter))); err != nil {
+			return err
+		}
+	}
+
+	for attempt := 1; ; attempt++ {
+		if r.healthy(ctx, p.healthCheckURL) {
+			return nil
+		}
+		if attempt >= p.attempts {
+			return errUnhealthy
+		}
+		if err := r.sleep(ctx, r.healthCheckInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// prewarm polls the health check URL once, until the plugin is closed,
+// recording the backend as warm if it is healthy.
+func (r *Retry) prewarm() {
+	if !r.healthy(r.ctx, r.healthCheckURL) {
+		r.log.warnf("prewarm of %s: backend is not healthy", r.name)
+		return
+	}
+	r.markWarm(defaultBackend, r.clock.Now())
+	r.log.infof("prewarm of %s: backend is healthy", r.name)
+}
+
+// keepWarm requests the health check URL every keep warm interval, until the plugin is closed,
+// recording the backend as warm whenever it is healthy.
+func (r *Retry) keepWarm() {
+	for {
+		if err := r.clock.Sleep(r.ctx, r.keepWarmInterval); err != nil {
+			return
+		}
+		if r.healthy(r.ctx, r.healthCheckURL) {
+			r.markWarm(defaultBackend, r.clock.Now())
+		}
+	}
+}
+
+// healthy reports whether a single poll of the health check URL target responded with 200
+// within the health check timeout.
+func (r *Retry) healthy(ctx context.Context, target string) bool {
+	if r.healthCheckTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.healthCheckTimeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return false
+	}
+	for key, value := range r.healthCheckHeaders {
+		if http.CanonicalHeaderKey(key) == "Host" {
+			req.Host = value
+			continue
+		}
+		req.Header.Set(key, value)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return false
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	return resp.StatusCode == http.StatusOK
+}
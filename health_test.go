@@ -0,0 +1,366 @@
+package plugindemo_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/madshargreave/traefik-sleep"
+)
+
+func TestWake(t *testing.T) {
+	var polls int32
+	health := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&polls, 1) <= 2 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer health.Close()
+
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 3
+	cfg.HealthCheckURL = health.URL
+	cfg.HealthCheckInterval = "10ms"
+
+	forwarded := 0
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		forwarded++
+		if p := atomic.LoadInt32(&polls); p != 3 {
+			t.Errorf("request forwarded after %d health check polls", p)
+		}
+	})
+
+	recorder := serve(t, cfg, next, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+	assertStatus(t, recorder, http.StatusOK)
+	if forwarded != 1 {
+		t.Errorf("invalid number of forwarded requests: %d", forwarded)
+	}
+}
+
+func TestWakeShared(t *testing.T) {
+	var polls int32
+	health := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&polls, 1)
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer health.Close()
+
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 3
+	cfg.HealthCheckURL = health.URL
+
+	var forwarded int32
+	handler, err := plugindemo.New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&forwarded, 1)
+	}), cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const requests = 20
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+			assertStatus(t, recorder, http.StatusOK)
+		}()
+	}
+	wg.Wait()
+
+	if p := atomic.LoadInt32(&polls); p != 1 {
+		t.Errorf("invalid number of health check polls: %d", p)
+	}
+	if f := atomic.LoadInt32(&forwarded); f != requests {
+		t.Errorf("invalid number of forwarded requests: %d", f)
+	}
+}
+
+func TestMaxConcurrentWakes(t *testing.T) {
+	var polling, maxPolling int32
+	health := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		n := atomic.AddInt32(&polling, 1)
+		defer atomic.AddInt32(&polling, -1)
+		for {
+			max := atomic.LoadInt32(&maxPolling)
+			if n <= max || atomic.CompareAndSwapInt32(&maxPolling, max, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}))
+	defer health.Close()
+
+	hosts := []string{"a.example.com", "b.example.com", "c.example.com", "d.example.com", "e.example.com", "f.example.com"}
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 2
+	cfg.MaxConcurrentWakes = 2
+	cfg.HostPolicies = map[string]plugindemo.RuleConfig{}
+	for _, host := range hosts {
+		// Each host has its own health check URL, so that their wakes are not shared.
+		cfg.HostPolicies[host] = plugindemo.RuleConfig{HealthCheckURL: health.URL + "/" + host}
+	}
+
+	var forwarded int32
+	handler, err := plugindemo.New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&forwarded, 1)
+	}), cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for _, host := range hosts {
+		wg.Add(1)
+		go func(host string) {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+			req.Host = host
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+			assertStatus(t, recorder, http.StatusOK)
+		}(host)
+	}
+	wg.Wait()
+
+	if max := atomic.LoadInt32(&maxPolling); max > 2 {
+		t.Errorf("invalid number of concurrent wakes: %d", max)
+	}
+	if n := atomic.LoadInt32(&forwarded); n != int32(len(hosts)) {
+		t.Errorf("invalid number of forwarded requests: %d", n)
+	}
+}
+
+func TestPrewarmOnStart(t *testing.T) {
+	captureLog(t)
+
+	polled := make(chan struct{}, 10)
+	health := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		polled <- struct{}{}
+	}))
+	defer health.Close()
+
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 3
+	cfg.HealthCheckURL = health.URL
+	cfg.PrewarmOnStart = true
+
+	handler, err := plugindemo.New(context.Background(), http.NotFoundHandler(), cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = handler.(*plugindemo.Retry).Close() }()
+
+	select {
+	case <-polled:
+	case <-time.After(time.Second):
+		t.Fatal("no prewarm request")
+	}
+	select {
+	case <-polled:
+		t.Error("more than one prewarm request")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestKeepWarm(t *testing.T) {
+	var pings int32
+	health := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&pings, 1)
+	}))
+	defer health.Close()
+
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 1
+	cfg.HealthCheckURL = health.URL
+	cfg.KeepWarmInterval = "1m"
+
+	clock := plugindemo.NewBlockingFakeClock(time.Now())
+	handler, err := plugindemo.NewWithClock(context.Background(), http.NotFoundHandler(), cfg, "demo-plugin", clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each ping is made before the keep-warm sleeps again.
+	for i := 0; i < 5; i++ {
+		clock.WaitSleepers(1)
+		clock.Advance(30 * time.Second)
+		clock.WaitSleepers(1)
+		clock.Advance(30 * time.Second)
+	}
+	clock.WaitSleepers(1)
+
+	if p := atomic.LoadInt32(&pings); p != 5 {
+		t.Errorf("invalid number of keep-warm pings: %d", p)
+	}
+
+	if err := handler.(*plugindemo.Retry).Close(); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(10 * time.Minute)
+	time.Sleep(20 * time.Millisecond)
+	if p := atomic.LoadInt32(&pings); p != 5 {
+		t.Errorf("keep-warm pings after close: %d", p)
+	}
+}
+
+func TestPrewarmWithoutHealthCheck(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 3
+	cfg.PrewarmOnStart = true
+
+	if _, err := plugindemo.New(context.Background(), http.NotFoundHandler(), cfg, "demo-plugin"); err == nil {
+		t.Error("expected an error for a prewarm without health check URL")
+	}
+}
+
+func TestWakeUnhealthy(t *testing.T) {
+	var polls int32
+	health := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&polls, 1)
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer health.Close()
+
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 2
+	cfg.HealthCheckURL = health.URL
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		t.Error("request forwarded to an unhealthy backend")
+	})
+
+	recorder := serve(t, cfg, next, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+	assertStatus(t, recorder, http.StatusServiceUnavailable)
+	if p := atomic.LoadInt32(&polls); p != 2 {
+		t.Errorf("invalid number of health check polls: %d", p)
+	}
+}
+
+func TestHealthCheckTimeout(t *testing.T) {
+	var polls int32
+	release := make(chan struct{})
+	health := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&polls, 1)
+		select {
+		case <-release:
+		case <-req.Context().Done():
+		}
+	}))
+	defer health.Close()
+	defer close(release)
+
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 3
+	cfg.HealthCheckURL = health.URL
+	cfg.HealthCheckInterval = "10ms"
+	cfg.HealthCheckTimeout = "50ms"
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		t.Error("request forwarded to a hanging backend")
+	})
+
+	start := time.Now()
+	recorder := serve(t, cfg, next, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+	assertStatus(t, recorder, http.StatusServiceUnavailable)
+	if p := atomic.LoadInt32(&polls); p != 3 {
+		t.Errorf("invalid number of health check polls: %d", p)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("polling did not give up in time: %v", elapsed)
+	}
+}
+
+func TestHealthCheckHeaders(t *testing.T) {
+	health := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Accept") != "application/health+json" {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer health.Close()
+
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 2
+	cfg.HealthCheckURL = health.URL
+	cfg.HealthCheckInterval = "10ms"
+	cfg.HealthCheckHeaders = map[string]string{"Accept": "application/health+json"}
+
+	forwarded := 0
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		forwarded++
+	})
+
+	recorder := serve(t, cfg, next, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+	assertStatus(t, recorder, http.StatusOK)
+	if forwarded != 1 {
+		t.Errorf("invalid number of forwarded requests: %d", forwarded)
+	}
+}
+
+func TestInvalidHealthCheckHeaders(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 1
+	cfg.HealthCheckURL = "http://localhost/health"
+	cfg.HealthCheckHeaders = map[string]string{"Bad Header": "value"}
+
+	if _, err := plugindemo.New(context.Background(), http.NotFoundHandler(), cfg, "demo-plugin"); err == nil {
+		t.Error("expected an error for an invalid health check header name")
+	}
+}
+
+func TestInvalidHealthCheckURL(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 1
+	cfg.HealthCheckURL = "localhost:8080/health"
+
+	if _, err := plugindemo.New(context.Background(), http.NotFoundHandler(), cfg, "demo-plugin"); err == nil {
+		t.Error("expected an error for an invalid health check URL")
+	}
+}
+
+func TestHealthCheckJitter(t *testing.T) {
+	health := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}))
+	defer health.Close()
+
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 1
+	cfg.HealthCheckURL = health.URL
+	cfg.HealthCheckJitter = "100ms"
+
+	handler, err := plugindemo.New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}), cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := plugindemo.NewFakeClock(time.Now())
+	handler.(*plugindemo.Retry).SetClock(clock)
+
+	const iterations = 100
+	for i := 0; i < iterations; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+	}
+
+	sleeps := clock.Sleeps()
+	if len(sleeps) < iterations/2 {
+		t.Fatalf("first polls were not delayed: %d sleeps", len(sleeps))
+	}
+	distinct := map[time.Duration]bool{}
+	for _, d := range sleeps {
+		if d <= 0 || d >= 100*time.Millisecond {
+			t.Errorf("jitter out of the window: %v", d)
+		}
+		distinct[d] = true
+	}
+	if len(distinct) < 10 {
+		t.Errorf("first polls are not spread out: %d distinct delays", len(distinct))
+	}
+}
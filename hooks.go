@@ -0,0 +1,57 @@
+package plugindemo
+
+import (
+	"context"
+	"net/http"
+)
+
+// PreAttempt is called with the request of each attempt before it is forwarded, starting with attempt 1.
+// The request is a copy made for the attempt, which can be modified without affecting the other attempts.
+type PreAttempt func(req *http.Request, attempt int)
+
+// NewWithPreAttempt creates a new Demo plugin calling preAttempt before each attempt.
+func NewWithPreAttempt(ctx context.Context, next http.Handler, config *Config, name string, preAttempt PreAttempt) (http.Handler, error) {
+	handler, err := New(ctx, next, config, name)
+	if err != nil {
+		return nil, err
+	}
+	if preAttempt != nil {
+		handler.(*Retry).preAttempt = preAttempt
+		handler.(*Retry).fastPath = false
+	}
+	return handler, nil
+}
+
+// PostResponse is called with the final response once the attempts are over, before it is sent to the client,
+// and returns the status and body to send in its place. The header can be modified in place.
+// It is not called for the responses already sent while streaming.
+type PostResponse func(status int, header http.Header, body []byte) (newStatus int, newBody []byte)
+
+// NewWithPostResponse creates a new Demo plugin transforming the final responses with postResponse.
+func NewWithPostResponse(ctx context.Context, next http.Handler, config *Config, name string, postResponse PostResponse) (http.Handler, error) {
+	handler, err := New(ctx, next, config, name)
+	if err != nil {
+		return nil, err
+	}
+	if postResponse != nil {
+		handler.(*Retry).postResponse = postResponse
+		handler.(*Retry).fastPath = false
+	}
+	return handler, nil
+}
+
+// transform replaces the status and body of sw with those returned by the post response hook, if any.
+func (r *Retry) transform(sw *statusWriter) {
+	if r.postResponse == nil || sw.committed || sw.hijacked {
+		return
+	}
+
+	status, body := r.postResponse(sw.StatusCode(), sw.Header(), sw.bodyBytes())
+	sw.status = status
+	sw.wroteHeader = true
+	sw.resetBody()
+	n, _ := sw.body.Write(body)
+	sw.length = n
+	sw.buffered = int64(n)
+	sw.Header().Del("Content-Length")
+}
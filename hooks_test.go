@@ -0,0 +1,75 @@
+package plugindemo_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/madshargreave/traefik-sleep"
+)
+
+func TestPreAttempt(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 3
+
+	var seen [][]string
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		seen = append(seen, req.Header.Values("X-Token"))
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	})
+	preAttempt := func(req *http.Request, attempt int) {
+		req.Header.Add("X-Token", "token-"+strconv.Itoa(attempt))
+	}
+
+	handler, err := plugindemo.NewWithPreAttempt(context.Background(), next, cfg, "demo-plugin", preAttempt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	expected := [][]string{{"token-1"}, {"token-2"}, {"token-3"}}
+	if !reflect.DeepEqual(seen, expected) {
+		t.Errorf("invalid headers seen by the backend: got %v, want %v", seen, expected)
+	}
+	if values := req.Header.Values("X-Token"); len(values) != 0 {
+		t.Errorf("pre-attempt mutation leaked into the original request: %v", values)
+	}
+}
+
+func TestPostResponse(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 2
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Length", "11")
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = rw.Write([]byte("upstream 503"))
+	})
+	postResponse := func(status int, header http.Header, body []byte) (int, []byte) {
+		if status != http.StatusServiceUnavailable {
+			return status, body
+		}
+		header.Set("X-Friendly", "true")
+		return http.StatusServiceUnavailable, []byte("The service is waking up, please retry in a moment.")
+	}
+
+	handler, err := plugindemo.NewWithPostResponse(context.Background(), next, cfg, "demo-plugin", postResponse)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+	assertStatus(t, recorder, http.StatusServiceUnavailable)
+	assertHeader(t, recorder.Header(), "X-Friendly", "true")
+	assertHeader(t, recorder.Header(), "Content-Length", "")
+	if recorder.Body.String() != "The service is waking up, please retry in a moment." {
+		t.Errorf("invalid body: %q", recorder.Body.String())
+	}
+}
@@ -0,0 +1,84 @@
+package plugindemo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// idempotencyKeyHeader is the header with which clients identify a request they may send again.
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// newIdempotencyCache returns the cache of the first successful response of each idempotency key,
+// or nil when disabled. It is bounded as the stale responses by MaxStaleBytes.
+func newIdempotencyCache(config *Config, ttl time.Duration) *staleCache {
+	if !config.HonorIdempotencyKey {
+		return nil
+	}
+	return &staleCache{ttl: ttl, maxBytes: config.MaxStaleBytes, entries: make(map[string]*staleEntry)}
+}
+
+// idempotencyKey returns the key of req in the idempotency cache, or "" when it has none.
+// It is scoped to the host, method and path of req, and to the credentials of the client,
+// so that a key reused by another client or for another request does not replay a response it should not get.
+func idempotencyKey(req *http.Request) string {
+	key := req.Header.Get(idempotencyKeyHeader)
+	if key == "" {
+		return ""
+	}
+	return strings.Join([]string{req.Host, req.Method, req.URL.Path, callerIdentity(req), key}, " ")
+}
+
+// callerIdentity returns a digest of the credentials of req, its Authorization and Cookie headers.
+func callerIdentity(req *http.Request) string {
+	h := sha256.New()
+	for _, name := range []string{"Authorization", "Cookie"} {
+		for _, value := range req.Header.Values(name) {
+			_, _ = h.Write([]byte(name + ": " + value + "\n"))
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// serveIdempotent returns the response cached for the idempotency key of req,
+// marked with an Idempotent-Replayed header, or nil when req should be forwarded.
+func (r *Retry) serveIdempotent(req *http.Request) *statusWriter {
+	key := idempotencyKey(req)
+	if r.idempotency == nil || key == "" {
+		return nil
+	}
+	entry := r.idempotency.get(key, r.clock.Now())
+	if entry == nil {
+		return nil
+	}
+
+	sw := newStatusWriter()
+	copyHeader(sw.Header(), entry.header.Clone())
+	sw.Header().Set("Idempotent-Replayed", "true")
+	sw.WriteHeader(entry.status)
+	_, _ = sw.Write(entry.body)
+	return sw
+}
+
+// storeIdempotent caches the successful response of a request with an idempotency key,
+// unless a response is already cached for it. The cookies set by the response are not replayed.
+func (r *Retry) storeIdempotent(req *http.Request, sw *statusWriter) {
+	key := idempotencyKey(req)
+	if r.idempotency == nil || key == "" || sw.committed || sw.hijacked {
+		return
+	}
+	now := r.clock.Now()
+	if r.idempotency.get(key, now) != nil {
+		return
+	}
+	header := sw.header.Clone()
+	header.Del("Set-Cookie")
+	r.idempotency.put(key, &staleEntry{
+		status: sw.StatusCode(),
+		header: header,
+		body:   append([]byte(nil), sw.bodyBytes()...),
+		stored: now,
+	})
+}
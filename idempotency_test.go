@@ -0,0 +1,185 @@
+package plugindemo_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/madshargreave/traefik-sleep"
+)
+
+func TestHonorIdempotencyKey(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 2
+	cfg.RetryIdempotentOnly = false
+	cfg.HonorIdempotencyKey = true
+
+	calls := 0
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		rw.Header().Set("X-Order", strconv.Itoa(calls))
+		rw.WriteHeader(http.StatusCreated)
+		_, _ = rw.Write([]byte("order " + strconv.Itoa(calls)))
+	})
+
+	handler, err := plugindemo.New(context.Background(), next, cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "http://localhost/orders", strings.NewReader("item=1"))
+		req.Header.Set("Idempotency-Key", key)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	first := request("key-1")
+	second := request("key-1")
+
+	if calls != 1 {
+		t.Errorf("invalid number of backend calls: %d", calls)
+	}
+	for _, recorder := range []*httptest.ResponseRecorder{first, second} {
+		assertStatus(t, recorder, http.StatusCreated)
+		assertHeader(t, recorder.Header(), "X-Order", "1")
+		if recorder.Body.String() != "order 1" {
+			t.Errorf("invalid body: %q", recorder.Body.String())
+		}
+	}
+	assertHeader(t, second.Header(), "Idempotent-Replayed", "true")
+
+	other := request("key-2")
+	if calls != 2 {
+		t.Errorf("request with another key not forwarded: %d calls", calls)
+	}
+	assertHeader(t, other.Header(), "X-Order", "2")
+}
+
+func TestHonorIdempotencyKeyFailure(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 1
+	cfg.HonorIdempotencyKey = true
+
+	calls := 0
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		if calls == 1 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+
+	handler, err := plugindemo.New(context.Background(), next, cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodPost, "http://localhost/orders", nil)
+		req.Header.Set("Idempotency-Key", "key-1")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// The failed response is not replayed, the first successful one is.
+	if calls != 2 {
+		t.Errorf("invalid number of backend calls: %d", calls)
+	}
+}
+
+func TestIdempotencyKeyScope(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 1
+	cfg.HonorIdempotencyKey = true
+
+	calls := 0
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		http.SetCookie(rw, &http.Cookie{Name: "session", Value: strconv.Itoa(calls)})
+		_, _ = rw.Write([]byte(req.Method + " " + req.URL.Path + " " + strconv.Itoa(calls)))
+	})
+
+	handler, err := plugindemo.New(context.Background(), next, cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := func(method, target, authorization string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("Idempotency-Key", "key-1")
+		req.Header.Set("Authorization", authorization)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	request(http.MethodPost, "http://localhost/users/alice/secret", "Bearer alice")
+
+	tests := []struct {
+		desc          string
+		method        string
+		target        string
+		authorization string
+	}{
+		{desc: "another method", method: http.MethodDelete, target: "http://localhost/users/alice/secret", authorization: "Bearer alice"},
+		{desc: "another path", method: http.MethodPost, target: "http://localhost/other", authorization: "Bearer alice"},
+		{desc: "another client", method: http.MethodPost, target: "http://localhost/users/alice/secret", authorization: "Bearer bob"},
+	}
+	for _, test := range tests {
+		recorder := request(test.method, test.target, test.authorization)
+		if recorder.Header().Get("Idempotent-Replayed") != "" {
+			t.Errorf("%s: response replayed: %q", test.desc, recorder.Body.String())
+		}
+	}
+	if calls != 1+len(tests) {
+		t.Errorf("invalid number of backend calls: %d", calls)
+	}
+
+	replayed := request(http.MethodPost, "http://localhost/users/alice/secret", "Bearer alice")
+	assertHeader(t, replayed.Header(), "Idempotent-Replayed", "true")
+	if replayed.Body.String() != "POST /users/alice/secret 1" {
+		t.Errorf("invalid body: %q", replayed.Body.String())
+	}
+	if cookies := replayed.Header().Values("Set-Cookie"); len(cookies) != 0 {
+		t.Errorf("cookies replayed: %v", cookies)
+	}
+}
+
+func TestIdempotencyKeyExpiry(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 1
+	cfg.HonorIdempotencyKey = true
+	cfg.IdempotencyKeyTTL = "1m"
+	cfg.MaxStaleBytes = 0
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusNoContent)
+	})
+
+	handler, err := plugindemo.New(context.Background(), next, cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	retry := handler.(*plugindemo.Retry)
+	clock := plugindemo.NewFakeClock(time.Now())
+	retry.SetClock(clock)
+	request := func(key string) {
+		req := httptest.NewRequest(http.MethodPost, "http://localhost/orders", nil)
+		req.Header.Set("Idempotency-Key", key)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	for i := 0; i < plugindemo.MaxStaleEntries+10; i++ {
+		request("key-" + strconv.Itoa(i))
+	}
+	if entries := retry.IdempotencyEntries(); entries != plugindemo.MaxStaleEntries {
+		t.Errorf("invalid number of entries: %d", entries)
+	}
+
+	clock.Advance(2 * time.Minute)
+	request("key-0")
+	if entries := retry.IdempotencyEntries(); entries != 1 {
+		t.Errorf("expired entries kept: %d", entries)
+	}
+}
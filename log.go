@@ -0,0 +1,189 @@
+package plugindemo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Access log formats.
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// Log levels, from the most to the least verbose.
+const (
+	logLevelDebug = "debug"
+	logLevelInfo  = "info"
+	logLevelWarn  = "warn"
+	logLevelError = "error"
+)
+
+var logLevels = map[string]int{logLevelDebug: 0, logLevelInfo: 1, logLevelWarn: 2, logLevelError: 3}
+
+// Request outcomes.
+const (
+	// outcomeOK is the outcome of a request that succeeded on the first attempt.
+	outcomeOK = "ok"
+	// outcomeRetriedOK is the outcome of a request that succeeded after being retried.
+	outcomeRetriedOK = "retried_ok"
+	// outcomeExhausted is the outcome of a request whose attempts all failed.
+	outcomeExhausted = "exhausted"
+)
+
+// LogEntry is a structured access log line.
+type LogEntry struct {
+	Host       string        `json:"host"`
+	RemoteAddr string        `json:"remoteAddr"`
+	Method     string        `json:"method"`
+	RequestURI string        `json:"requestURI"`
+	Proto      string        `json:"proto"`
+	Status     int           `json:"status"`
+	ContentLen int           `json:"contentLen"`
+	UserAgent  string        `json:"userAgent"`
+	Duration   time.Duration `json:"duration"`
+	RequestID  string        `json:"requestId,omitempty"`
+	Outcome    string        `json:"outcome"`
+}
+
+func validateLogFormat(format string) error {
+	switch format {
+	case "", logFormatText, logFormatJSON:
+		return nil
+	default:
+		return fmt.Errorf("incorrect value for log format (%s)", format)
+	}
+}
+
+func validateLogLevel(level string) error {
+	if _, ok := logLevels[level]; !ok && level != "" {
+		return fmt.Errorf("incorrect value for log level (%s)", level)
+	}
+	return nil
+}
+
+// Logger is the destination of the plugin log, each line being written at its level.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// stdLogger writes to the standard logger, the lines of the levels but info being prefixed with their level.
+type stdLogger struct{}
+
+func (stdLogger) Debugf(format string, args ...interface{}) { log.Printf("DEBUG "+format, args...) }
+func (stdLogger) Infof(format string, args ...interface{})  { log.Printf(format, args...) }
+func (stdLogger) Warnf(format string, args ...interface{})  { log.Printf("WARN "+format, args...) }
+func (stdLogger) Errorf(format string, args ...interface{}) { log.Printf("ERROR "+format, args...) }
+
+// levelLogger writes to logger the lines of its level and above, info when empty.
+type levelLogger struct {
+	logger Logger
+	level  int
+}
+
+func newLevelLogger(logger Logger, level string) *levelLogger {
+	if level == "" {
+		level = logLevelInfo
+	}
+	return &levelLogger{logger: logger, level: logLevels[level]}
+}
+
+func (l *levelLogger) debugf(format string, args ...interface{}) {
+	if l.level <= logLevels[logLevelDebug] {
+		l.logger.Debugf(format, args...)
+	}
+}
+
+func (l *levelLogger) infof(format string, args ...interface{}) {
+	if l.level <= logLevels[logLevelInfo] {
+		l.logger.Infof(format, args...)
+	}
+}
+
+func (l *levelLogger) warnf(format string, args ...interface{}) {
+	if l.level <= logLevels[logLevelWarn] {
+		l.logger.Warnf(format, args...)
+	}
+}
+
+func (l *levelLogger) errorf(format string, args ...interface{}) {
+	l.logger.Errorf(format, args...)
+}
+
+// outcome returns the outcome of a request served under p.
+func (r *Retry) outcome(p *policy, res result) string {
+	switch {
+	case res.exhausted || r.failed(p, res.sw):
+		return outcomeExhausted
+	case res.attempts > 1:
+		return outcomeRetriedOK
+	default:
+		return outcomeOK
+	}
+}
+
+// sampleFailure reports whether the access log line of a failed request is written,
+// one failure in every log sample rate being logged.
+func (r *Retry) sampleFailure() bool {
+	if r.logSampleRate <= 1 {
+		return true
+	}
+	return (atomic.AddInt64(&r.failureLogs, 1)-1)%int64(r.logSampleRate) == 0
+}
+
+// warnSlow writes a warning for a request which took longer than the slow request threshold.
+func (r *Retry) warnSlow(req *http.Request, sw *statusWriter, duration time.Duration, attempts int) {
+	if r.slowRequestThreshold <= 0 || duration <= r.slowRequestThreshold {
+		return
+	}
+	r.log.warnf("slow request: host: %v request: %v [%v] (%v) attempts: %d", req.Host, req.URL, sw.status, duration, attempts)
+}
+
+// logAccess writes the access log line of a request in the configured format, if enabled.
+func (r *Retry) logAccess(req *http.Request, sw *statusWriter, duration time.Duration, outcome string) {
+	if !r.accessLog || outcome == outcomeExhausted && !r.sampleFailure() {
+		return
+	}
+
+	if r.logFormat != logFormatJSON {
+		r.log.infof("host: %v request: %v [%v] (%v) outcome: %v", req.Host, req.URL, sw.status, duration, outcome)
+		return
+	}
+
+	line, err := json.Marshal(LogEntry{
+		Host:       req.Host,
+		RemoteAddr: req.RemoteAddr,
+		Method:     req.Method,
+		RequestURI: req.RequestURI,
+		Proto:      req.Proto,
+		Status:     sw.status,
+		ContentLen: sw.length,
+		UserAgent:  req.Header.Get("User-Agent"),
+		Duration:   duration,
+		RequestID:  RequestID(req.Context()),
+		Outcome:    outcome,
+	})
+	if err != nil {
+		r.log.errorf("unable to write access log: %v", err)
+		return
+	}
+	r.log.infof("%s", line)
+}
+
+// NewWithLogger creates a new Demo plugin writing its log to logger, at the configured log level.
+func NewWithLogger(ctx context.Context, next http.Handler, config *Config, name string, logger Logger) (http.Handler, error) {
+	r, err := newRetry(ctx, next, config, name, realClock{}, nil, logger)
+	if err != nil {
+		return nil, err
+	}
+	r.start()
+	return r, nil
+}
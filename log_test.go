@@ -0,0 +1,393 @@
+package plugindemo_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/madshargreave/traefik-sleep"
+)
+
+func TestJSONLog(t *testing.T) {
+	output := captureLog(t)
+
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 1
+	cfg.LogFormat = "json"
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusCreated)
+		_, _ = rw.Write([]byte("created"))
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/items?id=1", nil)
+	req.Header.Set("User-Agent", "test-agent")
+	serve(t, cfg, next, req)
+
+	var entry plugindemo.LogEntry
+	if err := json.Unmarshal(output.Bytes(), &entry); err != nil {
+		t.Fatalf("invalid JSON log line %q: %v", output.String(), err)
+	}
+
+	expected := plugindemo.LogEntry{
+		Host:       "localhost",
+		RemoteAddr: req.RemoteAddr,
+		Method:     http.MethodPost,
+		RequestURI: "http://localhost/items?id=1",
+		Proto:      "HTTP/1.1",
+		Status:     http.StatusCreated,
+		ContentLen: len("created"),
+		UserAgent:  "test-agent",
+		Duration:   entry.Duration,
+		RequestID:  req.Header.Get("X-Request-Id"),
+		Outcome:    "ok",
+	}
+	if entry != expected {
+		t.Errorf("invalid log entry: got %+v, want %+v", entry, expected)
+	}
+	if entry.Duration <= 0 {
+		t.Errorf("invalid duration: %v", entry.Duration)
+	}
+}
+
+func TestLogContentLength(t *testing.T) {
+	tests := []struct {
+		desc     string
+		apply    func(cfg *plugindemo.Config)
+		next     func(rw http.ResponseWriter, attempt int)
+		expected int
+	}{
+		{
+			desc: "multiple writes",
+			next: func(rw http.ResponseWriter, attempt int) {
+				for _, chunk := range []string{"ab", "cd", "ef"} {
+					_, _ = rw.Write([]byte(chunk))
+				}
+			},
+			expected: 6,
+		},
+		{
+			desc: "retried",
+			next: func(rw http.ResponseWriter, attempt int) {
+				if attempt == 1 {
+					rw.WriteHeader(http.StatusServiceUnavailable)
+					_, _ = rw.Write([]byte("unavailable"))
+					return
+				}
+				_, _ = rw.Write([]byte("ok"))
+			},
+			expected: 2,
+		},
+		{
+			desc: "streamed",
+			apply: func(cfg *plugindemo.Config) {
+				cfg.MaxResponseBufferBytes = 4
+			},
+			next: func(rw http.ResponseWriter, attempt int) {
+				for i := 0; i < 3; i++ {
+					_, _ = rw.Write([]byte("abc"))
+				}
+			},
+			expected: 9,
+		},
+		{
+			desc: "no content",
+			next: func(rw http.ResponseWriter, attempt int) {
+				rw.WriteHeader(http.StatusNoContent)
+			},
+			expected: 0,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			output := captureLog(t)
+
+			cfg := plugindemo.CreateConfig()
+			cfg.Attempts = 2
+			cfg.LogFormat = "json"
+			if test.apply != nil {
+				test.apply(cfg)
+			}
+
+			attempt := 0
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				attempt++
+				test.next(rw, attempt)
+			})
+
+			serve(t, cfg, next, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+			lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+			var entry plugindemo.LogEntry
+			if err := json.Unmarshal([]byte(lines[len(lines)-1]), &entry); err != nil {
+				t.Fatalf("invalid JSON log line %q: %v", output.String(), err)
+			}
+			if entry.ContentLen != test.expected {
+				t.Errorf("invalid content length: got %d, want %d", entry.ContentLen, test.expected)
+			}
+		})
+	}
+}
+
+func TestTextLog(t *testing.T) {
+	output := captureLog(t)
+
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 1
+
+	serve(t, cfg, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}), httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+	if !strings.Contains(output.String(), "host: localhost request: http://localhost [200]") {
+		t.Errorf("invalid log line: %q", output.String())
+	}
+}
+
+func TestAccessLogDisabled(t *testing.T) {
+	output := captureLog(t)
+
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 2
+	cfg.AccessLog = false
+
+	calls := 0
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		if calls == 1 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+
+	serve(t, cfg, next, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+	if strings.Contains(output.String(), "host: localhost") {
+		t.Errorf("access log line written while disabled: %q", output.String())
+	}
+	if !strings.Contains(output.String(), "retrying request http://localhost (attempt 2, status 503)") {
+		t.Errorf("missing retry log line: %q", output.String())
+	}
+}
+
+func TestLogSampleRate(t *testing.T) {
+	output := captureLog(t)
+
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 1
+	cfg.LogSampleRate = 10
+
+	handler, err := plugindemo.New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/fail" {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}), cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 100; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost/fail", nil))
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost/ok", nil))
+
+	if lines := strings.Count(output.String(), "outcome: exhausted"); lines != 10 {
+		t.Errorf("invalid number of failure log lines: %d", lines)
+	}
+	if lines := strings.Count(output.String(), "outcome: ok"); lines != 1 {
+		t.Errorf("invalid number of success log lines: %d", lines)
+	}
+}
+
+func TestInvalidLogFormat(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 1
+	cfg.LogFormat = "xml"
+
+	if _, err := plugindemo.New(context.Background(), http.NotFoundHandler(), cfg, "demo-plugin"); err == nil {
+		t.Error("expected an error for an invalid log format")
+	}
+}
+
+// captureLog redirects the standard logger to a buffer for the duration of the test.
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	var output bytes.Buffer
+	writer, flags := log.Writer(), log.Flags()
+	log.SetOutput(&output)
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(writer)
+		log.SetFlags(flags)
+	})
+
+	return &output
+}
+
+func TestOutcome(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		failures int
+		expected string
+	}{
+		{desc: "first attempt success", failures: 0, expected: "ok"},
+		{desc: "success after retry", failures: 2, expected: "retried_ok"},
+		{desc: "retries exhausted", failures: 3, expected: "exhausted"},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			output := captureLog(t)
+
+			cfg := plugindemo.CreateConfig()
+			cfg.Attempts = 3
+			cfg.LogFormat = "json"
+
+			calls := 0
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				if calls <= test.failures {
+					rw.WriteHeader(http.StatusServiceUnavailable)
+				}
+			})
+
+			serve(t, cfg, next, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+			lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+			var entry plugindemo.LogEntry
+			if err := json.Unmarshal([]byte(lines[len(lines)-1]), &entry); err != nil {
+				t.Fatalf("invalid JSON log line %q: %v", output.String(), err)
+			}
+			if entry.Outcome != test.expected {
+				t.Errorf("invalid outcome: got %q, want %q", entry.Outcome, test.expected)
+			}
+		})
+	}
+}
+
+func TestSlowRequestWarning(t *testing.T) {
+	tests := []struct {
+		desc     string
+		duration time.Duration
+		expected bool
+	}{
+		{desc: "slow", duration: 2 * time.Second, expected: true},
+		{desc: "fast", duration: 10 * time.Millisecond},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			output := captureLog(t)
+
+			cfg := plugindemo.CreateConfig()
+			cfg.Attempts = 2
+			cfg.AccessLog = false
+			cfg.SlowRequestThreshold = "1s"
+
+			clock := plugindemo.NewFakeClock(time.Now())
+			calls := 0
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				clock.Advance(test.duration / 2)
+				if calls == 1 {
+					rw.WriteHeader(http.StatusServiceUnavailable)
+				}
+			})
+
+			handler, err := plugindemo.New(context.Background(), next, cfg, "demo-plugin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			handler.(*plugindemo.Retry).SetClock(clock)
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+			logged := output.String()
+			if warned := strings.Contains(logged, "WARN slow request"); warned != test.expected {
+				t.Fatalf("invalid slow request warning %t: %q", warned, logged)
+			}
+			if test.expected && !strings.Contains(logged, "attempts: 2") {
+				t.Errorf("attempt count missing from the warning: %q", logged)
+			}
+		})
+	}
+}
+
+type capturingLogger struct {
+	lines []string
+}
+
+func (l *capturingLogger) Debugf(format string, args ...interface{}) { l.add("debug", format, args) }
+func (l *capturingLogger) Infof(format string, args ...interface{})  { l.add("info", format, args) }
+func (l *capturingLogger) Warnf(format string, args ...interface{})  { l.add("warn", format, args) }
+func (l *capturingLogger) Errorf(format string, args ...interface{}) { l.add("error", format, args) }
+
+func (l *capturingLogger) add(level, format string, args []interface{}) {
+	l.lines = append(l.lines, level+": "+fmt.Sprintf(format, args...))
+}
+
+func TestLogLevel(t *testing.T) {
+	tests := []struct {
+		desc     string
+		level    string
+		expected []string
+	}{
+		{desc: "debug", level: "debug", expected: []string{"debug", "info", "debug", "warn"}},
+		{desc: "info", level: "info", expected: []string{"info", "warn"}},
+		{desc: "warn", level: "warn", expected: []string{"warn"}},
+		{desc: "error", level: "error", expected: nil},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			cfg := plugindemo.CreateConfig()
+			cfg.Attempts = 2
+			cfg.RetryStatusCodes = []int{http.StatusServiceUnavailable}
+			cfg.LogLevel = test.level
+			cfg.AccessLog = false
+			cfg.SlowRequestThreshold = "1ns"
+
+			calls := 0
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				if calls == 1 {
+					rw.WriteHeader(http.StatusServiceUnavailable)
+				}
+			})
+
+			logger := &capturingLogger{}
+			handler, err := plugindemo.NewWithLogger(context.Background(), next, cfg, "demo-plugin", logger)
+			if err != nil {
+				t.Fatal(err)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+			var levels []string
+			for _, line := range logger.lines {
+				levels = append(levels, strings.SplitN(line, ":", 2)[0])
+			}
+			if !reflect.DeepEqual(levels, test.expected) {
+				t.Errorf("invalid log lines: %q", logger.lines)
+			}
+		})
+	}
+}
+
+func TestInvalidLogLevel(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.LogLevel = "verbose"
+
+	if _, err := plugindemo.New(context.Background(), http.NotFoundHandler(), cfg, "demo-plugin"); err == nil {
+		t.Error("expected an error")
+	}
+}
@@ -0,0 +1,35 @@
+package plugindemo
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// SetMaintenance turns the maintenance on or off,
+// the requests being answered with the maintenance response, without reaching the backend, while it is on.
+func (r *Retry) SetMaintenance(on bool) {
+	var maintenance int32
+	if on {
+		maintenance = 1
+	}
+	atomic.StoreInt32(&r.maintenance, maintenance)
+}
+
+// inMaintenance reports whether req should get the maintenance response,
+// because the maintenance is on or req carries the maintenance header.
+func (r *Retry) inMaintenance(req *http.Request) bool {
+	if atomic.LoadInt32(&r.maintenance) != 0 {
+		return true
+	}
+	return r.maintenanceHeader != "" && req.Header.Get(r.maintenanceHeader) != ""
+}
+
+func (r *Retry) writeMaintenance(rw http.ResponseWriter) {
+	if r.maintenanceBody != "" {
+		rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	rw.WriteHeader(r.maintenanceStatus)
+	if r.maintenanceBody != "" {
+		_, _ = rw.Write([]byte(r.maintenanceBody))
+	}
+}
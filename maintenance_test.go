@@ -0,0 +1,99 @@
+package plugindemo_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/madshargreave/traefik-sleep"
+)
+
+func TestMaintenance(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 1
+	cfg.MaintenanceBody = "down for maintenance"
+
+	calls := 0
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+	})
+
+	handler, err := plugindemo.New(context.Background(), next, cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	retry := handler.(*plugindemo.Retry)
+
+	retry.SetMaintenance(true)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+	assertStatus(t, recorder, http.StatusServiceUnavailable)
+	if recorder.Body.String() != "down for maintenance" {
+		t.Errorf("invalid body: %q", recorder.Body.String())
+	}
+	if calls != 0 {
+		t.Errorf("request forwarded during maintenance: %d", calls)
+	}
+
+	retry.SetMaintenance(false)
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+	assertStatus(t, recorder, http.StatusOK)
+	if calls != 1 {
+		t.Errorf("invalid number of calls after maintenance: %d", calls)
+	}
+}
+
+func TestMaintenanceHeader(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 1
+	cfg.MaintenanceHeader = "X-Maintenance"
+	cfg.MaintenanceStatus = http.StatusTeapot
+
+	calls := 0
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.Header.Set("X-Maintenance", "1")
+	recorder := serve(t, cfg, next, req)
+
+	assertStatus(t, recorder, http.StatusTeapot)
+	if calls != 0 {
+		t.Errorf("request forwarded during maintenance: %d", calls)
+	}
+
+	recorder = serve(t, cfg, next, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+	assertStatus(t, recorder, http.StatusOK)
+	if calls != 1 {
+		t.Errorf("invalid number of calls without the maintenance header: %d", calls)
+	}
+}
+
+func TestInvalidMaintenanceStatus(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 1
+	cfg.MaintenanceStatus = 1000
+
+	if _, err := plugindemo.New(context.Background(), http.NotFoundHandler(), cfg, "demo-plugin"); err == nil {
+		t.Error("expected an error for an invalid maintenance status")
+	}
+}
+
+func TestMaintenanceDefaultStatus(t *testing.T) {
+	// A configuration not made by CreateConfig answers the requests in maintenance with a 503.
+	handler, err := plugindemo.New(context.Background(), http.NotFoundHandler(), &plugindemo.Config{Attempts: 1}, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.(*plugindemo.Retry).SetMaintenance(true)
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+	assertStatus(t, recorder, http.StatusServiceUnavailable)
+}
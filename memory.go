@@ -0,0 +1,79 @@
+package plugindemo
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// memoryBudget bounds the memory buffered by all the requests in flight.
+// A nil budget is disabled and allows any buffering.
+type memoryBudget struct {
+	// used is kept first to guarantee its 64-bit alignment.
+	used  int64
+	limit int64
+}
+
+func newMemoryBudget(limit int64) (*memoryBudget, error) {
+	if limit < 0 {
+		return nil, fmt.Errorf("incorrect value for max total buffer bytes (%d)", limit)
+	}
+	if limit == 0 {
+		return nil, nil
+	}
+	return &memoryBudget{limit: limit}, nil
+}
+
+// full reports whether the whole budget is in use.
+func (m *memoryBudget) full() bool {
+	return m != nil && atomic.LoadInt64(&m.used) >= m.limit
+}
+
+// reservation returns a new reservation, empty, of the memory buffered by a request.
+func (m *memoryBudget) reservation() *reservation {
+	if m == nil {
+		return nil
+	}
+	return &reservation{budget: m}
+}
+
+// reservation is the memory reserved by a request from a budget, released once the request is served.
+// A nil reservation always succeeds.
+type reservation struct {
+	// n is kept first to guarantee its 64-bit alignment.
+	n      int64
+	budget *memoryBudget
+}
+
+// reserve reserves n more bytes, reporting false when that would exceed the budget.
+func (r *reservation) reserve(n int) bool {
+	if r == nil || n == 0 {
+		return true
+	}
+	for {
+		used := atomic.LoadInt64(&r.budget.used)
+		if used+int64(n) > r.budget.limit {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&r.budget.used, used, used+int64(n)) {
+			atomic.AddInt64(&r.n, int64(n))
+			return true
+		}
+	}
+}
+
+// free gives back n of the bytes reserved so far.
+func (r *reservation) free(n int64) {
+	if r == nil || n == 0 {
+		return
+	}
+	atomic.AddInt64(&r.n, -n)
+	atomic.AddInt64(&r.budget.used, -n)
+}
+
+// release gives back all the bytes reserved so far.
+func (r *reservation) release() {
+	if r == nil {
+		return
+	}
+	atomic.AddInt64(&r.budget.used, -atomic.SwapInt64(&r.n, 0))
+}
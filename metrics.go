@@ -0,0 +1,141 @@
+package plugindemo
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultLatencyBuckets are the upper bounds, in seconds, of the attempt latency buckets when none are configured.
+var defaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Metrics holds the retry counters of a plugin instance.
+// The counters are updated atomically, use Snapshot to read them.
+type Metrics struct {
+	// Requests is the number of requests served.
+	Requests int64 `json:"requests"`
+	// Retries is the number of attempts made after the first one.
+	Retries int64 `json:"retries"`
+	// RetriesExhausted is the number of requests that still failed after being retried.
+	RetriesExhausted int64 `json:"retriesExhausted"`
+	// SuccessAfterRetry is the number of requests that succeeded after being retried.
+	SuccessAfterRetry int64 `json:"successAfterRetry"`
+	// Panics is the number of attempts whose handler panicked.
+	Panics int64 `json:"panics"`
+	// DroppedEvents is the number of retry events dropped because the events channel was full.
+	DroppedEvents int64 `json:"droppedEvents"`
+}
+
+// Snapshot returns a copy of the counters.
+func (m *Metrics) Snapshot() Metrics {
+	return Metrics{
+		Requests:          atomic.LoadInt64(&m.Requests),
+		Retries:           atomic.LoadInt64(&m.Retries),
+		RetriesExhausted:  atomic.LoadInt64(&m.RetriesExhausted),
+		SuccessAfterRetry: atomic.LoadInt64(&m.SuccessAfterRetry),
+		Panics:            atomic.LoadInt64(&m.Panics),
+		DroppedEvents:     atomic.LoadInt64(&m.DroppedEvents),
+	}
+}
+
+// observe records a request that took the given number of attempts.
+func (m *Metrics) observe(attempts int, failed bool) {
+	atomic.AddInt64(&m.Requests, 1)
+	if attempts <= 1 {
+		return
+	}
+
+	atomic.AddInt64(&m.Retries, int64(attempts-1))
+	if failed {
+		atomic.AddInt64(&m.RetriesExhausted, 1)
+	} else {
+		atomic.AddInt64(&m.SuccessAfterRetry, 1)
+	}
+}
+
+// histogram counts observed durations in buckets.
+type histogram struct {
+	// buckets are the upper bounds of the buckets in seconds, in increasing order.
+	buckets []float64
+
+	mu sync.Mutex
+	// counts holds the number of observations of each bucket, the last one being the +Inf bucket.
+	counts []int64
+	sum    float64
+}
+
+func newHistogram(buckets []float64) (*histogram, error) {
+	if len(buckets) == 0 {
+		buckets = defaultLatencyBuckets
+	}
+	for i, bucket := range buckets {
+		if bucket <= 0 || i > 0 && bucket <= buckets[i-1] {
+			return nil, fmt.Errorf("incorrect value for attempt latency buckets (%v)", buckets)
+		}
+	}
+
+	return &histogram{buckets: buckets, counts: make([]int64, len(buckets)+1)}, nil
+}
+
+// observe records a duration.
+func (h *histogram) observe(d time.Duration) {
+	seconds := d.Seconds()
+	i := sort.SearchFloat64s(h.buckets, seconds)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.counts[i]++
+	h.sum += seconds
+}
+
+// write writes the histogram in the Prometheus text exposition format.
+func (h *histogram) write(rw http.ResponseWriter, name, metric, help string) {
+	h.mu.Lock()
+	counts := append([]int64(nil), h.counts...)
+	sum := h.sum
+	h.mu.Unlock()
+
+	metric = "traefik_sleep_" + metric
+	_, _ = fmt.Fprintf(rw, "# HELP %s %s\n# TYPE %s histogram\n", metric, help, metric)
+
+	var cumulative int64
+	for i, count := range counts {
+		cumulative += count
+		le := "+Inf"
+		if i < len(h.buckets) {
+			le = strconv.FormatFloat(h.buckets[i], 'g', -1, 64)
+		}
+		_, _ = fmt.Fprintf(rw, "%s_bucket{middleware=%q,le=%q} %d\n", metric, name, le, cumulative)
+	}
+	_, _ = fmt.Fprintf(rw, "%s_sum{middleware=%q} %v\n%s_count{middleware=%q} %d\n", metric, name, sum, metric, name, cumulative)
+}
+
+// Metrics returns a snapshot of the plugin counters.
+func (r *Retry) Metrics() Metrics {
+	return r.metrics.Snapshot()
+}
+
+// MetricsHandler returns a handler serving the plugin counters in the Prometheus text exposition format.
+func (r *Retry) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		snapshot := r.metrics.Snapshot()
+
+		rw.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeCounter(rw, r.name, "requests_total", "Total number of requests.", snapshot.Requests)
+		writeCounter(rw, r.name, "retries_total", "Total number of retry attempts.", snapshot.Retries)
+		writeCounter(rw, r.name, "retries_exhausted_total", "Total number of requests that failed after all attempts.", snapshot.RetriesExhausted)
+		writeCounter(rw, r.name, "success_after_retry_total", "Total number of requests that succeeded after a retry.", snapshot.SuccessAfterRetry)
+		writeCounter(rw, r.name, "panics_total", "Total number of attempts whose handler panicked.", snapshot.Panics)
+		writeCounter(rw, r.name, "dropped_events_total", "Total number of retry events dropped.", snapshot.DroppedEvents)
+		r.latency.write(rw, r.name, "attempt_duration_seconds", "Latency of each attempt.")
+	})
+}
+
+func writeCounter(rw http.ResponseWriter, name, metric, help string, value int64) {
+	metric = "traefik_sleep_" + metric
+	_, _ = fmt.Fprintf(rw, "# HELP %s %s\n# TYPE %s counter\n%s{middleware=%q} %d\n", metric, help, metric, metric, name, value)
+}
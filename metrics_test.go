@@ -0,0 +1,164 @@
+package plugindemo_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/madshargreave/traefik-sleep"
+)
+
+func TestMetrics(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 3
+
+	// Each request fails the number of times given by its path length.
+	calls := map[string]int{}
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls[req.URL.Path]++
+		if calls[req.URL.Path] < len(req.URL.Path) {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+
+	handler, err := plugindemo.New(context.Background(), next, cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	retry := handler.(*plugindemo.Retry)
+
+	for _, path := range []string{"/", "/a", "/aa", "/aaa"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost"+path, nil))
+	}
+
+	expected := plugindemo.Metrics{Requests: 4, Retries: 5, RetriesExhausted: 1, SuccessAfterRetry: 2}
+	if got := retry.Metrics(); got != expected {
+		t.Errorf("invalid metrics: got %+v, want %+v", got, expected)
+	}
+
+	recorder := httptest.NewRecorder()
+	retry.MetricsHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://localhost/metrics", nil))
+
+	for _, line := range []string{
+		"# TYPE traefik_sleep_requests_total counter",
+		`traefik_sleep_requests_total{middleware="demo-plugin"} 4`,
+		`traefik_sleep_retries_total{middleware="demo-plugin"} 5`,
+		`traefik_sleep_retries_exhausted_total{middleware="demo-plugin"} 1`,
+		`traefik_sleep_success_after_retry_total{middleware="demo-plugin"} 2`,
+	} {
+		if !strings.Contains(recorder.Body.String(), line+"\n") {
+			t.Errorf("missing line %q in:\n%s", line, recorder.Body.String())
+		}
+	}
+}
+
+func TestAttemptLatencyHistogram(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 2
+	cfg.AttemptLatencyBuckets = []float64{0.1, 1}
+
+	clock := plugindemo.NewFakeClock(time.Now())
+
+	// Each attempt takes the duration given by the request path.
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		d, err := time.ParseDuration(strings.TrimPrefix(req.URL.Path, "/"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		clock.Advance(d)
+		if d > time.Second {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+
+	handler, err := plugindemo.New(context.Background(), next, cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	retry := handler.(*plugindemo.Retry)
+	retry.SetClock(clock)
+
+	for _, path := range []string{"/50ms", "/500ms", "/2s"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost"+path, nil))
+	}
+
+	recorder := httptest.NewRecorder()
+	retry.MetricsHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://localhost/metrics", nil))
+
+	for _, line := range []string{
+		"# TYPE traefik_sleep_attempt_duration_seconds histogram",
+		`traefik_sleep_attempt_duration_seconds_bucket{middleware="demo-plugin",le="0.1"} 1`,
+		`traefik_sleep_attempt_duration_seconds_bucket{middleware="demo-plugin",le="1"} 2`,
+		`traefik_sleep_attempt_duration_seconds_bucket{middleware="demo-plugin",le="+Inf"} 4`,
+		`traefik_sleep_attempt_duration_seconds_sum{middleware="demo-plugin"} 4.55`,
+		`traefik_sleep_attempt_duration_seconds_count{middleware="demo-plugin"} 4`,
+	} {
+		if !strings.Contains(recorder.Body.String(), line+"\n") {
+			t.Errorf("missing line %q in:\n%s", line, recorder.Body.String())
+		}
+	}
+}
+
+func TestInvalidAttemptLatencyBuckets(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 1
+	cfg.AttemptLatencyBuckets = []float64{1, 0.5}
+
+	if _, err := plugindemo.New(context.Background(), http.NotFoundHandler(), cfg, "demo-plugin"); err == nil {
+		t.Error("expected an error for unsorted buckets")
+	}
+}
+
+func TestRetryOnPanic(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 2
+	cfg.RetryOnPanic = true
+
+	calls := 0
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		if calls == 1 {
+			rw.Header().Set("X-Partial", "true")
+			_, _ = rw.Write([]byte("partial"))
+			panic("handler bug")
+		}
+		_, _ = rw.Write([]byte("ok"))
+	})
+
+	handler, err := plugindemo.New(context.Background(), next, cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+	assertStatus(t, recorder, http.StatusOK)
+	if recorder.Body.String() != "ok" || recorder.Header().Get("X-Partial") != "" {
+		t.Errorf("response of the panicking attempt was not discarded: %q %v", recorder.Body.String(), recorder.Header())
+	}
+	if panics := handler.(*plugindemo.Retry).Metrics().Panics; panics != 1 {
+		t.Errorf("invalid number of panics: %d", panics)
+	}
+}
+
+func TestPanicNotRetried(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 2
+
+	calls := 0
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		panic("handler bug")
+	})
+
+	recorder := serve(t, cfg, next, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+	assertStatus(t, recorder, http.StatusInternalServerError)
+	if calls != 1 {
+		t.Errorf("invalid number of attempts: %d", calls)
+	}
+}
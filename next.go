@@ -0,0 +1,114 @@
+package plugindemo
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// serveNext makes an attempt with the next handler, writing to sw, req being bounded by the attempt timeout if any.
+// When the handler does not start responding within the first byte timeout, or does not complete
+// its response within the attempt timeout while it is still buffered, the attempt is abandoned
+// and a gateway timeout is returned in place of sw, the handler being left to complete in the background.
+// When the handler panics, the response it wrote is replaced.
+func (r *Retry) serveNext(sw *statusWriter, req *http.Request, parent context.Context, attemptTimeout time.Duration) *statusWriter {
+	if r.firstByteTimeout <= 0 && attemptTimeout <= 0 {
+		if recovered := r.callNext(sw, req); recovered != nil {
+			return r.panicked(sw, req, recovered)
+		}
+		return sw
+	}
+
+	ctx, cancel := context.WithCancel(req.Context())
+	defer cancel()
+
+	done := make(chan struct{})
+	var recovered interface{}
+	var started <-chan struct{}
+	var firstByte <-chan time.Time
+	if r.firstByteTimeout > 0 {
+		sw.started = make(chan struct{})
+		started = sw.started
+		timer := time.NewTimer(r.firstByteTimeout)
+		defer timer.Stop()
+		firstByte = timer.C
+	}
+	var expired <-chan struct{}
+	if attemptTimeout > 0 {
+		expired = req.Context().Done()
+	}
+	go func() {
+		defer close(done)
+		recovered = r.callNext(sw, req.WithContext(ctx))
+	}()
+
+	for waiting := true; waiting; {
+		select {
+		case <-done:
+			waiting = false
+		case <-started:
+			started, firstByte = nil, nil
+		case <-firstByte:
+			firstByte = nil
+			if sw.abandon() {
+				r.log.warnf("request %v abandoned: no response within %v", req.URL, r.firstByteTimeout)
+				return gatewayTimeout()
+			}
+		case <-expired:
+			expired = nil
+			// The handler is only abandoned on the timeout of the attempt, the request being interrupted otherwise.
+			if parent.Err() == nil && sw.discard() {
+				return gatewayTimeout()
+			}
+		}
+	}
+
+	if recovered != nil {
+		return r.panicked(sw, req, recovered)
+	}
+	return sw
+}
+
+// callNext calls the next handler, returning the value it panicked with, if any.
+func (r *Retry) callNext(rw http.ResponseWriter, req *http.Request) (recovered interface{}) {
+	defer func() {
+		recovered = recover()
+	}()
+
+	r.next.ServeHTTP(rw, req)
+	return nil
+}
+
+// panicked returns the response used in place of sw when the next handler panicked with recovered.
+// The panic goes on when the handler aborted on purpose,
+// or when the response can no longer be replaced since it was already sent.
+func (r *Retry) panicked(sw *statusWriter, req *http.Request, recovered interface{}) *statusWriter {
+	if recovered == http.ErrAbortHandler || sw.committed || sw.hijacked {
+		panic(recovered)
+	}
+	r.log.errorf("recovered from panic serving request %v: %v", req.URL, recovered)
+	atomic.AddInt64(&r.metrics.Panics, 1)
+
+	sw = newStatusWriter()
+	sw.noResponse = true
+	sw.panicked = true
+	sw.WriteHeader(http.StatusInternalServerError)
+	return sw
+}
+
+// badGateway returns the response used when an attempt failed to write its response before anything was sent.
+func badGateway() *statusWriter {
+	sw := newStatusWriter()
+	sw.noResponse = true
+	sw.WriteHeader(http.StatusBadGateway)
+	return sw
+}
+
+// gatewayTimeout returns the response used when an attempt was abandoned.
+func gatewayTimeout() *statusWriter {
+	sw := newStatusWriter()
+	sw.noResponse = true
+	sw.WriteHeader(http.StatusGatewayTimeout)
+	return sw
+}
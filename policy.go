@@ -0,0 +1,306 @@
+package plugindemo
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RuleConfig overrides the retry policy for the requests whose path starts with PathPrefix,
+// or for the requests to a host of HostPolicies, PathPrefix being unused then.
+// Unset fields inherit the top-level configuration.
+type RuleConfig struct {
+	PathPrefix       string
+	Attempts         int
+	Delay            string
+	RetryStatusCodes []int
+	HealthCheckURL   string
+}
+
+// PathRegexDelay overrides the wake delay for the requests whose path matches Pattern.
+type PathRegexDelay struct {
+	Pattern string
+	Delay   string
+}
+
+// defaultBackend is the backend of the top-level policy and of the path rules.
+const defaultBackend = ""
+
+// policy is the part of the configuration that can be overridden per request,
+// or updated at runtime.
+type policy struct {
+	attempts int
+	delay    time.Duration
+	// retryStatusCodes and retryStatusRanges are both nil when all 5xx statuses are retried.
+	retryStatusCodes  map[int]bool
+	retryStatusRanges statusRanges
+	backoff
+	healthCheckURL string
+	// backend identifies the backend whose warm state applies: the host of a host policy, or the default backend.
+	backend string
+}
+
+// policies are the top-level policy and the rules overriding it.
+// They are never modified once built, UpdateConfig replacing them as a whole.
+type policies struct {
+	// base is the top-level policy, applied when no rule matches.
+	base  policy
+	rules []rule
+	// hosts are the policies of the hosts having their own, by normalized host.
+	hosts map[string]*policy
+	// pathDelays override the delay of the policy applying to the requests whose path they match, in order.
+	pathDelays []pathDelay
+}
+
+type pathDelay struct {
+	pattern *regexp.Regexp
+	delay   time.Duration
+}
+
+type rule struct {
+	pathPrefix string
+	policy     policy
+}
+
+// isRetryable reports whether a response with the given status should be retried.
+func (p *policy) isRetryable(status int) bool {
+	if p.retryStatusCodes == nil && p.retryStatusRanges == nil {
+		return status >= http.StatusInternalServerError
+	}
+	return p.retryStatusCodes[status] || p.retryStatusRanges.contains(status)
+}
+
+// newPolicy returns the policy configured by the top-level configuration.
+func newPolicy(config *Config) (policy, error) {
+	if config.Attempts <= 0 {
+		return policy{}, fmt.Errorf("incorrect (or empty) value for attempt (%d)", config.Attempts)
+	}
+	if err := validateMaxAttempts(config.Attempts, config.MaxAttempts); err != nil {
+		return policy{}, err
+	}
+
+	delay, err := parseDuration("delay", config.Delay)
+	if err != nil {
+		return policy{}, err
+	}
+	retryStatusCodes, err := parseStatusCodes(config.RetryStatusCodes)
+	if err != nil {
+		return policy{}, err
+	}
+	retryStatusRanges, err := parseStatusRanges(config.RetryStatusRanges)
+	if err != nil {
+		return policy{}, err
+	}
+	b, err := newBackoff(config)
+	if err != nil {
+		return policy{}, err
+	}
+
+	return policy{
+		attempts:          config.Attempts,
+		delay:             delay,
+		retryStatusCodes:  retryStatusCodes,
+		retryStatusRanges: retryStatusRanges,
+		backoff:           b,
+		healthCheckURL:    config.HealthCheckURL,
+	}, nil
+}
+
+// newPolicies returns the top-level policy and the rules of config.
+func newPolicies(config *Config) (*policies, error) {
+	base, err := newPolicy(config)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := newRules(base, config)
+	if err != nil {
+		return nil, err
+	}
+	hosts, err := newHostPolicies(base, config)
+	if err != nil {
+		return nil, err
+	}
+	pathDelays, err := newPathDelays(config)
+	if err != nil {
+		return nil, err
+	}
+	return &policies{base: base, rules: rules, hosts: hosts, pathDelays: pathDelays}, nil
+}
+
+// newPathDelays compiles the path regex delays of config.
+func newPathDelays(config *Config) ([]pathDelay, error) {
+	pathDelays := make([]pathDelay, 0, len(config.PathRegexDelays))
+	for _, delayConfig := range config.PathRegexDelays {
+		pattern, err := regexp.Compile(delayConfig.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("incorrect value for path regex delay pattern (%s): %w", delayConfig.Pattern, err)
+		}
+		delay, err := parseDuration("path regex delay", delayConfig.Delay)
+		if err != nil {
+			return nil, fmt.Errorf("path regex delay %s: %w", delayConfig.Pattern, err)
+		}
+		pathDelays = append(pathDelays, pathDelay{pattern: pattern, delay: delay})
+	}
+	return pathDelays, nil
+}
+
+func validateMaxAttempts(attempts, maxAttempts int) error {
+	if maxAttempts <= 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+	if attempts > maxAttempts {
+		return fmt.Errorf("value for attempt (%d) exceeds the maximum (%d)", attempts, maxAttempts)
+	}
+	return nil
+}
+
+// override returns a copy of p with the fields set in config overridden.
+func (p policy) override(config RuleConfig, maxAttempts int) (policy, error) {
+	if config.Attempts < 0 {
+		return policy{}, fmt.Errorf("incorrect value for attempt (%d)", config.Attempts)
+	}
+	if config.Attempts > 0 {
+		if err := validateMaxAttempts(config.Attempts, maxAttempts); err != nil {
+			return policy{}, err
+		}
+		p.attempts = config.Attempts
+	}
+
+	if config.Delay != "" {
+		delay, err := parseDuration("delay", config.Delay)
+		if err != nil {
+			return policy{}, err
+		}
+		p.delay = delay
+	}
+
+	if config.RetryStatusCodes != nil {
+		retryStatusCodes, err := parseStatusCodes(config.RetryStatusCodes)
+		if err != nil {
+			return policy{}, err
+		}
+		p.retryStatusCodes = retryStatusCodes
+		p.retryStatusRanges = nil
+	}
+
+	if config.HealthCheckURL != "" {
+		if err := validateURL("health check URL", config.HealthCheckURL); err != nil {
+			return policy{}, err
+		}
+		p.healthCheckURL = config.HealthCheckURL
+	}
+
+	return p, nil
+}
+
+// newRules returns the configured rules, sorted so that the longest prefixes match first.
+func newRules(base policy, config *Config) ([]rule, error) {
+	rules := make([]rule, 0, len(config.Rules))
+	for _, ruleConfig := range config.Rules {
+		if !strings.HasPrefix(ruleConfig.PathPrefix, "/") {
+			return nil, fmt.Errorf("incorrect value for rule path prefix (%s)", ruleConfig.PathPrefix)
+		}
+
+		p, err := base.override(ruleConfig, config.MaxAttempts)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %w", ruleConfig.PathPrefix, err)
+		}
+		rules = append(rules, rule{pathPrefix: ruleConfig.PathPrefix, policy: p})
+	}
+
+	sort.SliceStable(rules, func(i, j int) bool {
+		return len(rules[i].pathPrefix) > len(rules[j].pathPrefix)
+	})
+	return rules, nil
+}
+
+// newHostPolicies returns the configured host policies, by normalized host.
+func newHostPolicies(base policy, config *Config) (map[string]*policy, error) {
+	if len(config.HostPolicies) == 0 {
+		return nil, nil
+	}
+
+	hosts := make(map[string]*policy, len(config.HostPolicies))
+	for host, ruleConfig := range config.HostPolicies {
+		if host == "" {
+			return nil, errors.New("empty host policy host")
+		}
+		p, err := base.override(ruleConfig, config.MaxAttempts)
+		if err != nil {
+			return nil, fmt.Errorf("host policy %s: %w", host, err)
+		}
+		p.backend = normalizeHost(host)
+		hosts[p.backend] = &p
+	}
+	return hosts, nil
+}
+
+// normalizeHost returns host in lower case, without port.
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
+
+// policyFor returns the policy of the request host if it has one,
+// otherwise the policy of the longest rule prefix matching the request path,
+// or the top-level policy if none does.
+func (r *Retry) policyFor(req *http.Request) *policy {
+	ps := r.currentPolicies()
+	p := ps.matching(req)
+	for _, d := range ps.pathDelays {
+		if d.pattern.MatchString(req.URL.Path) {
+			withDelay := *p
+			withDelay.delay = d.delay
+			return &withDelay
+		}
+	}
+	return p
+}
+
+// matching returns the policy of the host of req if it has one, or of the longest rule matching its path,
+// or the top-level policy.
+func (ps *policies) matching(req *http.Request) *policy {
+	if len(ps.hosts) > 0 {
+		if p, ok := ps.hosts[normalizeHost(req.Host)]; ok {
+			return p
+		}
+	}
+	for i := range ps.rules {
+		if strings.HasPrefix(req.URL.Path, ps.rules[i].pathPrefix) {
+			return &ps.rules[i].policy
+		}
+	}
+	return &ps.base
+}
+
+func (r *Retry) currentPolicies() *policies {
+	r.policiesMu.RLock()
+	defer r.policiesMu.RUnlock()
+	return r.policies
+}
+
+// UpdateConfig replaces the attempts, delay, retry statuses, backoff, rules, host policies and path regex delays
+// of the plugin with those of config. The requests already being served keep the previous settings.
+// A config that New would reject is not applied, the current settings being kept.
+func (r *Retry) UpdateConfig(config *Config) error {
+	config = withEnv(config)
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	ps, err := newPolicies(config)
+	if err != nil {
+		return err
+	}
+
+	r.policiesMu.Lock()
+	defer r.policiesMu.Unlock()
+	r.policies = ps
+	return nil
+}
@@ -0,0 +1,309 @@
+package plugindemo_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/madshargreave/traefik-sleep"
+)
+
+func TestRules(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 3
+	cfg.Rules = []plugindemo.RuleConfig{
+		{PathPrefix: "/api", Attempts: 2},
+		{PathPrefix: "/api/slow", Attempts: 4},
+		{PathPrefix: "/limited", RetryStatusCodes: []int{http.StatusTooManyRequests}},
+	}
+
+	testCases := []struct {
+		path     string
+		status   int
+		expected int
+	}{
+		{path: "/", status: http.StatusServiceUnavailable, expected: 3},
+		{path: "/api/users", status: http.StatusServiceUnavailable, expected: 2},
+		{path: "/api/slow/report", status: http.StatusServiceUnavailable, expected: 4},
+		{path: "/limited", status: http.StatusServiceUnavailable, expected: 1},
+		{path: "/limited", status: http.StatusTooManyRequests, expected: 3},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.path, func(t *testing.T) {
+			calls := 0
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				rw.WriteHeader(test.status)
+			})
+
+			serve(t, cfg, next, httptest.NewRequest(http.MethodGet, "http://localhost"+test.path, nil))
+
+			if calls != test.expected {
+				t.Errorf("invalid number of attempts: %d", calls)
+			}
+		})
+	}
+}
+
+func TestInvalidRules(t *testing.T) {
+	testCases := []struct {
+		desc string
+		rule plugindemo.RuleConfig
+	}{
+		{desc: "relative prefix", rule: plugindemo.RuleConfig{PathPrefix: "api"}},
+		{desc: "negative attempts", rule: plugindemo.RuleConfig{PathPrefix: "/api", Attempts: -1}},
+		{desc: "too many attempts", rule: plugindemo.RuleConfig{PathPrefix: "/api", Attempts: 11}},
+		{desc: "invalid delay", rule: plugindemo.RuleConfig{PathPrefix: "/api", Delay: "abc"}},
+		{desc: "invalid status code", rule: plugindemo.RuleConfig{PathPrefix: "/api", RetryStatusCodes: []int{42}}},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			cfg := plugindemo.CreateConfig()
+			cfg.Attempts = 1
+			cfg.Rules = []plugindemo.RuleConfig{test.rule}
+
+			if _, err := plugindemo.New(context.Background(), http.NotFoundHandler(), cfg, "demo-plugin"); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestUpdateConfig(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 2
+
+	var calls int32
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	handler, err := plugindemo.New(context.Background(), next, cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	retry := handler.(*plugindemo.Retry)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+			}
+		}()
+	}
+	for i := 0; i < 50; i++ {
+		update := plugindemo.CreateConfig()
+		update.Attempts = 1 + i%3
+		update.BackoffBase = "1ns"
+		if err := retry.UpdateConfig(update); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+
+	update := plugindemo.CreateConfig()
+	update.Attempts = 3
+	if err := retry.UpdateConfig(update); err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt32(&calls, 0)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+	if n := atomic.LoadInt32(&calls); n != 3 {
+		t.Errorf("updated attempts not applied: %d attempts", n)
+	}
+}
+
+func TestUpdateConfigInvalid(t *testing.T) {
+	testCases := []struct {
+		desc   string
+		config func(cfg *plugindemo.Config)
+	}{
+		{desc: "invalid delay", config: func(cfg *plugindemo.Config) { cfg.Delay = "soon" }},
+		{desc: "invalid health check URL", config: func(cfg *plugindemo.Config) { cfg.HealthCheckURL = "localhost:8080/health" }},
+		{desc: "invalid maintenance status", config: func(cfg *plugindemo.Config) { cfg.MaintenanceStatus = 1000 }},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			cfg := plugindemo.CreateConfig()
+			cfg.Attempts = 2
+
+			calls := 0
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				rw.WriteHeader(http.StatusServiceUnavailable)
+			})
+
+			handler, err := plugindemo.New(context.Background(), next, cfg, "demo-plugin")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			update := plugindemo.CreateConfig()
+			update.Attempts = 3
+			test.config(update)
+			if err := handler.(*plugindemo.Retry).UpdateConfig(update); err == nil {
+				t.Fatal("expected an error for an invalid configuration")
+			}
+
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+			if calls != 2 {
+				t.Errorf("invalid configuration applied: %d attempts", calls)
+			}
+		})
+	}
+}
+
+func TestHostPolicies(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 2
+	cfg.Delay = "100ms"
+	cfg.Rules = []plugindemo.RuleConfig{{PathPrefix: "/api", Delay: "300ms"}}
+	cfg.HostPolicies = map[string]plugindemo.RuleConfig{
+		"a.example.com":      {Delay: "1s"},
+		"B.example.com:8080": {Delay: "2s", Attempts: 3},
+	}
+
+	testCases := []struct {
+		host     string
+		path     string
+		delay    time.Duration
+		attempts int
+	}{
+		{host: "a.example.com", path: "/", delay: time.Second, attempts: 2},
+		{host: "A.Example.com:443", path: "/api", delay: time.Second, attempts: 2},
+		{host: "b.example.com", path: "/", delay: 2 * time.Second, attempts: 3},
+		{host: "c.example.com", path: "/", delay: 100 * time.Millisecond, attempts: 2},
+		{host: "c.example.com", path: "/api", delay: 300 * time.Millisecond, attempts: 2},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.host+test.path, func(t *testing.T) {
+			calls := 0
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				rw.WriteHeader(http.StatusServiceUnavailable)
+			})
+
+			handler, err := plugindemo.New(context.Background(), next, cfg, "demo-plugin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			clock := plugindemo.NewFakeClock(time.Now())
+			handler.(*plugindemo.Retry).SetClock(clock)
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost"+test.path, nil)
+			req.Host = test.host
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			if sleeps := clock.Sleeps(); len(sleeps) == 0 || sleeps[0] != test.delay {
+				t.Errorf("invalid wake delay: %v", sleeps)
+			}
+			if calls != test.attempts {
+				t.Errorf("invalid number of attempts: %d", calls)
+			}
+		})
+	}
+}
+
+func TestPathRegexDelays(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 1
+	cfg.Delay = "100ms"
+	cfg.PathRegexDelays = []plugindemo.PathRegexDelay{
+		{Pattern: `^/reports/[0-9]+$`, Delay: "2s"},
+		{Pattern: `\.pdf$`, Delay: "1s"},
+	}
+
+	testCases := []struct {
+		path  string
+		delay time.Duration
+	}{
+		{path: "/reports/42", delay: 2 * time.Second},
+		{path: "/files/report.pdf", delay: time.Second},
+		{path: "/reports/latest", delay: 100 * time.Millisecond},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.path, func(t *testing.T) {
+			handler, err := plugindemo.New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}), cfg, "demo-plugin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			clock := plugindemo.NewFakeClock(time.Now())
+			handler.(*plugindemo.Retry).SetClock(clock)
+
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost"+test.path, nil))
+
+			if sleeps := clock.Sleeps(); len(sleeps) == 0 || sleeps[0] != test.delay {
+				t.Errorf("invalid wake delay: %v", sleeps)
+			}
+		})
+	}
+}
+
+func TestInvalidPathRegexDelays(t *testing.T) {
+	for _, pathDelay := range []plugindemo.PathRegexDelay{{Pattern: "(", Delay: "1s"}, {Pattern: "^/reports", Delay: "soon"}} {
+		cfg := plugindemo.CreateConfig()
+		cfg.Attempts = 1
+		cfg.PathRegexDelays = []plugindemo.PathRegexDelay{pathDelay}
+
+		if _, err := plugindemo.New(context.Background(), http.NotFoundHandler(), cfg, "demo-plugin"); err == nil {
+			t.Errorf("expected an error for %+v", pathDelay)
+		}
+	}
+}
+
+func TestHostPoliciesWarmState(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 2
+	cfg.WakeCacheTTL = "1m"
+	cfg.HostPolicies = map[string]plugindemo.RuleConfig{
+		"a.example.com": {Delay: "1s"},
+		"b.example.com": {Delay: "2s"},
+	}
+
+	handler, err := plugindemo.New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}), cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := plugindemo.NewFakeClock(time.Now())
+	handler.(*plugindemo.Retry).SetClock(clock)
+
+	for _, host := range []string{"a.example.com", "a.example.com", "b.example.com"} {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+		req.Host = host
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	expected := []time.Duration{time.Second, 2 * time.Second}
+	if sleeps := clock.Sleeps(); !reflect.DeepEqual(sleeps, expected) {
+		t.Errorf("invalid wake delays: got %v, want %v", sleeps, expected)
+	}
+}
+
+func TestInvalidHostPolicies(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 2
+	cfg.HostPolicies = map[string]plugindemo.RuleConfig{"a.example.com": {HealthCheckURL: "localhost/health"}}
+
+	if _, err := plugindemo.New(context.Background(), http.NotFoundHandler(), cfg, "demo-plugin"); err == nil {
+		t.Error("expected an error for an invalid host policy health check URL")
+	}
+}
@@ -0,0 +1,118 @@
+package plugindemo
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ratioBuckets is the number of buckets the window of a retry ratio is split into.
+const ratioBuckets = 10
+
+// retryRatio limits the retries made by all the clients to a percentage of the requests
+// over a sliding window, so that the retries cannot dominate the traffic when the backend fails.
+// A nil ratio is disabled and allows all retries.
+type retryRatio struct {
+	percent float64
+	// floor is the number of retries always allowed within the window.
+	floor  float64
+	window time.Duration
+
+	mu      sync.Mutex
+	buckets [ratioBuckets]ratioBucket
+}
+
+// ratioBucket counts the requests and retries made within a slice of the window.
+type ratioBucket struct {
+	index    int64
+	requests int
+	retries  int
+}
+
+func newRetryRatio(percent float64, minPerSecond int, window time.Duration) (*retryRatio, error) {
+	if percent < 0 || percent > 100 {
+		return nil, fmt.Errorf("incorrect value for retry budget percent (%v)", percent)
+	}
+	if minPerSecond < 0 {
+		return nil, fmt.Errorf("incorrect value for min retries per second (%d)", minPerSecond)
+	}
+	if percent == 0 {
+		return nil, nil
+	}
+	if window < ratioBuckets*time.Millisecond {
+		return nil, fmt.Errorf("incorrect value for retry budget window (%s)", window)
+	}
+
+	return &retryRatio{
+		percent: percent,
+		floor:   float64(minPerSecond) * window.Seconds(),
+		window:  window,
+	}, nil
+}
+
+// request counts a request made at now.
+func (b *retryRatio) request(now time.Time) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.bucket(now).requests++
+}
+
+// allow reports whether a retry may be made at now, counting it if so:
+// the retries within the window, this one included, must stay within the percentage of the requests,
+// or within the floor.
+func (b *retryRatio) allow(now time.Time) bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	current := b.bucket(now)
+	var requests, retries int
+	for i := range b.buckets {
+		if bucket := &b.buckets[i]; bucket.index > current.index-ratioBuckets {
+			requests += bucket.requests
+			retries += bucket.retries
+		}
+	}
+
+	allowed := b.percent / 100 * float64(requests)
+	if allowed < b.floor {
+		allowed = b.floor
+	}
+	if float64(retries+1) > allowed {
+		return false
+	}
+	current.retries++
+	return true
+}
+
+// refund forgets a retry counted by allow at now, when it was not made after all.
+func (b *retryRatio) refund(now time.Time) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if current := b.bucket(now); current.retries > 0 {
+		current.retries--
+	}
+}
+
+// bucket returns the bucket of now, emptied if it was last used for an older slice of the window.
+func (b *retryRatio) bucket(now time.Time) *ratioBucket {
+	index := now.UnixNano() / int64(b.window/ratioBuckets)
+	bucket := &b.buckets[index%ratioBuckets]
+	if bucket.index != index {
+		*bucket = ratioBucket{index: index}
+	}
+	return bucket
+}
@@ -0,0 +1,100 @@
+package plugindemo_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/madshargreave/traefik-sleep"
+)
+
+func TestRetryBudgetPercent(t *testing.T) {
+	tests := []struct {
+		desc                string
+		minRetriesPerSecond int
+		expCalls            int
+	}{
+		{
+			desc: "throttled past the percentage",
+			// Each request spends a retry of the budget once 5 requests were made.
+			expCalls: 10 + 2,
+		},
+		{
+			desc:                "floor",
+			minRetriesPerSecond: 1,
+			expCalls:            10 + 10,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			cfg := plugindemo.CreateConfig()
+			cfg.Attempts = 3
+			cfg.RetryBudgetPercent = 20
+			cfg.MinRetriesPerSecond = test.minRetriesPerSecond
+			cfg.RetryBudgetWindow = "10s"
+
+			calls := 0
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				rw.WriteHeader(http.StatusServiceUnavailable)
+			})
+
+			handler, err := plugindemo.New(context.Background(), next, cfg, "demo-plugin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			clock := plugindemo.NewFakeClock(time.Now())
+			handler.(*plugindemo.Retry).SetClock(clock)
+
+			for i := 0; i < 10; i++ {
+				handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+			}
+			if calls != test.expCalls {
+				t.Errorf("invalid number of attempts: %d, expected %d", calls, test.expCalls)
+			}
+
+			// The requests and retries are forgotten once out of the window.
+			clock.Advance(10 * time.Second)
+			calls = 0
+			for i := 0; i < 5; i++ {
+				handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+			}
+			if test.minRetriesPerSecond == 0 && calls != 5+1 {
+				t.Errorf("invalid number of attempts past the window: %d", calls)
+			}
+		})
+	}
+}
+
+func TestRetryBudgetPercentValidation(t *testing.T) {
+	tests := []struct {
+		desc   string
+		config func(cfg *plugindemo.Config)
+	}{
+		{desc: "percent above 100", config: func(cfg *plugindemo.Config) { cfg.RetryBudgetPercent = 150 }},
+		{desc: "negative min retries per second", config: func(cfg *plugindemo.Config) {
+			cfg.RetryBudgetPercent = 20
+			cfg.MinRetriesPerSecond = -1
+		}},
+		{desc: "missing window", config: func(cfg *plugindemo.Config) {
+			cfg.RetryBudgetPercent = 20
+			cfg.RetryBudgetWindow = ""
+		}},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			cfg := plugindemo.CreateConfig()
+			test.config(cfg)
+
+			if _, err := plugindemo.New(context.Background(), http.NotFoundHandler(), cfg, "demo-plugin"); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
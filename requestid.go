@@ -0,0 +1,41 @@
+package plugindemo
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+)
+
+type requestIDKey struct{}
+
+// RequestID returns the request ID stored in ctx by the plugin, if any.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// withRequestID makes sure req carries a request ID, in both its header and its context.
+func (r *Retry) withRequestID(req *http.Request) *http.Request {
+	if r.requestIDHeader == "" {
+		return req
+	}
+
+	id := req.Header.Get(r.requestIDHeader)
+	if id == "" {
+		id = newRequestID()
+		req.Header.Set(r.requestIDHeader, id)
+	}
+	return req.WithContext(context.WithValue(req.Context(), requestIDKey{}, id))
+}
+
+// newRequestID returns a random UUID (version 4).
+func newRequestID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return ""
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
@@ -0,0 +1,59 @@
+package plugindemo_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/madshargreave/traefik-sleep"
+)
+
+func TestRequestID(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		incoming string
+	}{
+		{desc: "generated"},
+		{desc: "incoming", incoming: "abc-123"},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			output := captureLog(t)
+
+			cfg := plugindemo.CreateConfig()
+			cfg.Attempts = 1
+			cfg.LogFormat = "json"
+
+			var forwarded, stored string
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				forwarded = req.Header.Get("X-Request-Id")
+				stored = plugindemo.RequestID(req.Context())
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+			if test.incoming != "" {
+				req.Header.Set("X-Request-Id", test.incoming)
+			}
+			serve(t, cfg, next, req)
+
+			var entry plugindemo.LogEntry
+			if err := json.Unmarshal(output.Bytes(), &entry); err != nil {
+				t.Fatal(err)
+			}
+
+			if test.incoming != "" && forwarded != test.incoming {
+				t.Errorf("incoming request ID was not kept: %q", forwarded)
+			}
+			if test.incoming == "" && !regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(forwarded) {
+				t.Errorf("invalid generated request ID: %q", forwarded)
+			}
+			if stored != forwarded || entry.RequestID != forwarded {
+				t.Errorf("request IDs differ: forwarded %q, context %q, log %q", forwarded, stored, entry.RequestID)
+			}
+		})
+	}
+}
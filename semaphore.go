@@ -0,0 +1,81 @@
+package plugindemo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Behaviors when all the concurrency slots are taken.
+const (
+	onFullQueue  = "queue"
+	onFullReject = "reject"
+)
+
+var errFull = errors.New("too many concurrent requests")
+
+func validateOnFull(onFull string) error {
+	switch onFull {
+	case "", onFullQueue, onFullReject:
+		return nil
+	default:
+		return fmt.Errorf("incorrect value for on full (%s)", onFull)
+	}
+}
+
+// acquire takes a concurrency slot, if concurrency is limited.
+// When all slots are taken, it either fails right away or waits for a slot to be released,
+// until ctx is done or the plugin is closed.
+func (r *Retry) acquire(ctx context.Context) error {
+	if r.slots == nil {
+		return nil
+	}
+
+	select {
+	case r.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	if r.onFull == onFullReject {
+		return errFull
+	}
+
+	select {
+	case r.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-r.ctx.Done():
+		return errClosed
+	}
+}
+
+// release gives back a slot taken by acquire.
+func (r *Retry) release() {
+	if r.slots != nil {
+		<-r.slots
+	}
+}
+
+// acquireWake takes a wake slot, if the concurrent wakes are limited,
+// waiting for one to be released until ctx is done.
+func (r *Retry) acquireWake(ctx context.Context) error {
+	if r.wakeSlots == nil {
+		return nil
+	}
+
+	select {
+	case r.wakeSlots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// releaseWake gives back a slot taken by acquireWake.
+func (r *Retry) releaseWake() {
+	if r.wakeSlots != nil {
+		<-r.wakeSlots
+	}
+}
@@ -0,0 +1,76 @@
+package plugindemo_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/madshargreave/traefik-sleep"
+)
+
+func TestMaxConcurrent(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		onFull   string
+		expected []int
+	}{
+		{desc: "reject", onFull: "reject", expected: []int{http.StatusOK, http.StatusOK, http.StatusServiceUnavailable}},
+		{desc: "queue", onFull: "queue", expected: []int{http.StatusOK, http.StatusOK, http.StatusOK}},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			cfg := plugindemo.CreateConfig()
+			cfg.Attempts = 1
+			cfg.MaxConcurrent = 2
+			cfg.OnFull = test.onFull
+
+			entered := make(chan struct{}, 3)
+			unblock := make(chan struct{})
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				entered <- struct{}{}
+				<-unblock
+			})
+
+			handler, err := plugindemo.New(context.Background(), next, cfg, "demo-plugin")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			statuses := make([]int, 3)
+			var wg sync.WaitGroup
+			send := func(i int) {
+				defer wg.Done()
+				recorder := httptest.NewRecorder()
+				handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+				statuses[i] = recorder.Code
+			}
+
+			wg.Add(3)
+			go send(0)
+			go send(1)
+			<-entered
+			<-entered
+
+			go send(2)
+			select {
+			case <-entered:
+				t.Fatal("request exceeding the concurrency limit reached the backend")
+			case <-time.After(20 * time.Millisecond):
+			}
+
+			close(unblock)
+			wg.Wait()
+
+			for i, status := range statuses {
+				if status != test.expected[i] {
+					t.Errorf("request %d: invalid status %d", i, status)
+				}
+			}
+		})
+	}
+}
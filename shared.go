@@ -0,0 +1,40 @@
+package plugindemo
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+)
+
+type attemptBudgetKey struct{}
+
+// attemptBudget is the number of retries left to all the retrying middlewares a request goes through.
+type attemptBudget struct {
+	retries int64
+}
+
+// withAttemptBudget returns req carrying a shared attempt budget of the configured number of attempts,
+// unless it already carries one from an outer middleware.
+func (r *Retry) withAttemptBudget(req *http.Request) *http.Request {
+	if r.sharedAttempts == 0 || req.Context().Value(attemptBudgetKey{}) != nil {
+		return req
+	}
+
+	budget := &attemptBudget{retries: int64(r.sharedAttempts - 1)}
+	return req.WithContext(context.WithValue(req.Context(), attemptBudgetKey{}, budget))
+}
+
+// takeRetry reports whether the shared attempt budget of ctx, if any, allows one more retry, spending it if so.
+// Every backend call but the first one being caused by a retry, the backend is called at most as many times
+// as the shared attempts, however many retrying middlewares are stacked.
+func takeRetry(ctx context.Context) bool {
+	budget, ok := ctx.Value(attemptBudgetKey{}).(*attemptBudget)
+	if !ok {
+		return true
+	}
+	if atomic.AddInt64(&budget.retries, -1) < 0 {
+		atomic.AddInt64(&budget.retries, 1)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,65 @@
+package plugindemo_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/madshargreave/traefik-sleep"
+)
+
+func TestSharedAttempts(t *testing.T) {
+	tests := []struct {
+		desc     string
+		shared   int
+		expected int
+	}{
+		{desc: "unlimited", expected: 9},
+		{desc: "shared budget", shared: 4, expected: 4},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			calls := 0
+			backend := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				rw.WriteHeader(http.StatusServiceUnavailable)
+			})
+
+			innerCfg := plugindemo.CreateConfig()
+			innerCfg.Attempts = 3
+			inner, err := plugindemo.New(context.Background(), backend, innerCfg, "inner")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			outerCfg := plugindemo.CreateConfig()
+			outerCfg.Attempts = 3
+			outerCfg.SharedAttempts = test.shared
+			outer, err := plugindemo.New(context.Background(), inner, outerCfg, "outer")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			outer.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+			assertStatus(t, recorder, http.StatusServiceUnavailable)
+			if calls != test.expected {
+				t.Errorf("invalid number of backend calls: %d", calls)
+			}
+		})
+	}
+}
+
+func TestInvalidSharedAttempts(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 1
+	cfg.SharedAttempts = -1
+
+	if _, err := plugindemo.New(context.Background(), http.NotFoundHandler(), cfg, "demo-plugin"); err == nil {
+		t.Error("expected an error for negative shared attempts")
+	}
+}
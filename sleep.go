@@ -0,0 +1,115 @@
+package plugindemo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// statusClientClosedRequest is the non-standard status used when the client
+// went away before a response could be sent.
+const statusClientClosedRequest = 499
+
+var errClosed = errors.New("plugin closed")
+
+// Behaviors when the max sleeping requests are already sleeping.
+const (
+	onSleepSaturatedForward = "forward"
+	onSleepSaturatedReject  = "reject"
+)
+
+var errSleepSaturated = errors.New("too many sleeping requests")
+
+func validateOnSleepSaturated(onSleepSaturated string) error {
+	switch onSleepSaturated {
+	case "", onSleepSaturatedForward, onSleepSaturatedReject:
+		return nil
+	default:
+		return fmt.Errorf("incorrect value for on sleep saturated (%s)", onSleepSaturated)
+	}
+}
+
+// sleep waits for d, or until ctx is done in which case the context error is returned,
+// or until the plugin is closed.
+func (r *Retry) sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-r.ctx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	err := r.clock.Sleep(ctx, d)
+	if err != nil && r.ctx.Err() != nil {
+		return errClosed
+	}
+	return err
+}
+
+// sleepRequest waits for d on behalf of a request, as sleep, unless the max sleeping requests are already sleeping.
+// The wait is then either skipped, or fails with errSleepSaturated when the saturated requests are rejected.
+func (r *Retry) sleepRequest(ctx context.Context, d time.Duration) error {
+	if r.maxSleepingRequests <= 0 || d <= 0 {
+		return r.sleep(ctx, d)
+	}
+
+	for {
+		sleeping := atomic.LoadInt64(&r.sleeping)
+		if sleeping >= r.maxSleepingRequests {
+			if r.onSleepSaturated == onSleepSaturatedReject {
+				return errSleepSaturated
+			}
+			return nil
+		}
+		if atomic.CompareAndSwapInt64(&r.sleeping, sleeping, sleeping+1) {
+			break
+		}
+	}
+	defer atomic.AddInt64(&r.sleeping, -1)
+	return r.sleep(ctx, d)
+}
+
+// delayFor returns the delay to wait before forwarding req: the one requested by the client
+// in the delay header when allowed and valid, capped to the max delay, or the delay of p otherwise.
+func (r *Retry) delayFor(req *http.Request, p *policy) time.Duration {
+	if r.delayHeader == "" {
+		return p.delay
+	}
+	value := req.Header.Get(r.delayHeader)
+	if value == "" {
+		return p.delay
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return p.delay
+	}
+	if d > r.maxDelay {
+		return r.maxDelay
+	}
+	return d
+}
+
+// smooth waits, before sending the successful response sw, until the min response time elapsed since start,
+// or until the client goes away.
+func (r *Retry) smooth(req *http.Request, sw *statusWriter, start time.Time) {
+	if r.minResponseTime <= 0 || sw.committed || sw.hijacked || sw.StatusCode() >= http.StatusBadRequest {
+		return
+	}
+	_ = r.sleep(req.Context(), r.minResponseTime-r.clock.Now().Sub(start))
+}
+
+// Close cancels all pending sleeps and makes the plugin reject new requests.
+func (r *Retry) Close() error {
+	r.cancel()
+	return nil
+}
@@ -0,0 +1,233 @@
+package plugindemo_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/madshargreave/traefik-sleep"
+)
+
+func TestClose(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 1
+	cfg.Delay = "1m"
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		t.Error("request forwarded after the plugin was closed")
+	})
+
+	handler, err := plugindemo.New(context.Background(), next, cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	retry := handler.(*plugindemo.Retry)
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+		done <- recorder
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	if err := retry.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case recorder := <-done:
+		assertStatus(t, recorder, http.StatusServiceUnavailable)
+	case <-time.After(time.Second):
+		t.Fatal("sleeping request was not unblocked by Close")
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+	assertStatus(t, recorder, http.StatusServiceUnavailable)
+}
+
+func TestHeaderDelay(t *testing.T) {
+	tests := []struct {
+		desc     string
+		value    string
+		expected time.Duration
+	}{
+		{desc: "valid", value: "2s", expected: 2 * time.Second},
+		{desc: "oversized", value: "1h", expected: 5 * time.Second},
+		{desc: "malformed", value: "soon", expected: 100 * time.Millisecond},
+		{desc: "negative", value: "-1s", expected: 100 * time.Millisecond},
+		{desc: "missing", expected: 100 * time.Millisecond},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			cfg := plugindemo.CreateConfig()
+			cfg.Attempts = 1
+			cfg.Delay = "100ms"
+			cfg.AllowHeaderDelay = true
+			cfg.MaxDelay = "5s"
+
+			handler, err := plugindemo.New(context.Background(), http.NotFoundHandler(), cfg, "demo-plugin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			clock := plugindemo.NewFakeClock(time.Now())
+			handler.(*plugindemo.Retry).SetClock(clock)
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+			if test.value != "" {
+				req.Header.Set("X-Wake-Delay", test.value)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			sleeps := clock.Sleeps()
+			if len(sleeps) != 1 || sleeps[0] != test.expected {
+				t.Errorf("invalid sleeps: %v, expected %v", sleeps, test.expected)
+			}
+		})
+	}
+}
+
+func TestHeaderDelayNotAllowed(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 1
+
+	handler, err := plugindemo.New(context.Background(), http.NotFoundHandler(), cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := plugindemo.NewFakeClock(time.Now())
+	handler.(*plugindemo.Retry).SetClock(clock)
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.Header.Set("X-Wake-Delay", "2s")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if sleeps := clock.Sleeps(); len(sleeps) != 0 {
+		t.Errorf("header delay used while not allowed: %v", sleeps)
+	}
+}
+
+func TestMinResponseTime(t *testing.T) {
+	tests := []struct {
+		desc     string
+		duration time.Duration
+		status   int
+		expected []time.Duration
+	}{
+		{desc: "fast", duration: 300 * time.Millisecond, status: http.StatusOK, expected: []time.Duration{700 * time.Millisecond}},
+		{desc: "slow", duration: 2 * time.Second, status: http.StatusOK},
+		{desc: "failed", status: http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			cfg := plugindemo.CreateConfig()
+			cfg.Attempts = 1
+			cfg.MinResponseTime = "1s"
+
+			clock := plugindemo.NewFakeClock(time.Now())
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				clock.Advance(test.duration)
+				rw.WriteHeader(test.status)
+			})
+
+			handler, err := plugindemo.New(context.Background(), next, cfg, "demo-plugin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			handler.(*plugindemo.Retry).SetClock(clock)
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+			assertStatus(t, recorder, test.status)
+			if sleeps := clock.Sleeps(); !reflect.DeepEqual(sleeps, test.expected) {
+				t.Errorf("invalid sleeps: got %v, want %v", sleeps, test.expected)
+			}
+		})
+	}
+}
+
+func TestMaxSleepingRequests(t *testing.T) {
+	tests := []struct {
+		desc             string
+		onSleepSaturated string
+		expForwarded     int32
+	}{
+		{desc: "forward", onSleepSaturated: "forward", expForwarded: 6},
+		{desc: "reject", onSleepSaturated: "reject", expForwarded: 2},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			cfg := plugindemo.CreateConfig()
+			cfg.Attempts = 1
+			cfg.Delay = "100ms"
+			cfg.MaxSleepingRequests = 2
+			cfg.OnSleepSaturated = test.onSleepSaturated
+
+			var forwarded int32
+			handler, err := plugindemo.New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				atomic.AddInt32(&forwarded, 1)
+			}), cfg, "demo-plugin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			retry := handler.(*plugindemo.Retry)
+
+			done, sampled := make(chan struct{}), make(chan struct{})
+			var maxSleeping int64
+			go func() {
+				defer close(sampled)
+				for {
+					select {
+					case <-done:
+						return
+					default:
+					}
+					if sleeping := retry.Status().Sleeping; sleeping > maxSleeping {
+						maxSleeping = sleeping
+					}
+					time.Sleep(time.Millisecond)
+				}
+			}()
+
+			var wg sync.WaitGroup
+			var rejected int32
+			for i := 0; i < 6; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					recorder := httptest.NewRecorder()
+					handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+					if recorder.Code == http.StatusServiceUnavailable {
+						atomic.AddInt32(&rejected, 1)
+					}
+				}()
+			}
+			wg.Wait()
+			close(done)
+			<-sampled
+
+			if maxSleeping > 2 {
+				t.Errorf("invalid number of sleeping requests: %d", maxSleeping)
+			}
+			if n := atomic.LoadInt32(&forwarded); n != test.expForwarded {
+				t.Errorf("invalid number of forwarded requests: %d", n)
+			}
+			if n := atomic.LoadInt32(&rejected); n != 6-test.expForwarded {
+				t.Errorf("invalid number of rejected requests: %d", n)
+			}
+		})
+	}
+}
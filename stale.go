@@ -0,0 +1,139 @@
+package plugindemo
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxStaleEntries is the number of responses a stale cache keeps at most,
+// whatever their size, the keys being chosen by the clients.
+const maxStaleEntries = 10000
+
+// staleCache keeps the last successful response of the GET requests, to serve while the backend is waking up.
+// A nil cache is disabled.
+type staleCache struct {
+	ttl      time.Duration
+	maxBytes int64
+
+	mu      sync.Mutex
+	size    int64
+	entries map[string]*staleEntry
+	// swept is when the expired entries were last removed.
+	swept time.Time
+}
+
+// staleEntry is a cached response.
+type staleEntry struct {
+	status int
+	header http.Header
+	body   []byte
+	stored time.Time
+}
+
+func newStaleCache(config *Config, ttl time.Duration) *staleCache {
+	if !config.ServeStaleWhileWaking {
+		return nil
+	}
+	return &staleCache{ttl: ttl, maxBytes: config.MaxStaleBytes, entries: make(map[string]*staleEntry)}
+}
+
+// get returns the response cached for key if it is younger than the stale TTL at now, or nil.
+func (c *staleCache) get(key string, now time.Time) *staleEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	if now.Sub(entry.stored) >= c.ttl {
+		c.remove(key)
+		return nil
+	}
+	return entry
+}
+
+// put caches entry for key, evicting the oldest entries to stay within the max stale bytes and entries.
+// An entry larger than the max stale bytes is not cached.
+func (c *staleCache) put(key string, entry *staleEntry) {
+	size := int64(len(entry.body))
+	if c.ttl <= 0 || c.maxBytes > 0 && size > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.remove(key)
+	c.sweep(entry.stored)
+	for c.maxBytes > 0 && c.size+size > c.maxBytes || len(c.entries) >= maxStaleEntries {
+		c.remove(c.oldest())
+	}
+	c.entries[key] = entry
+	c.size += size
+}
+
+// sweep removes the entries expired at now, at most once per TTL.
+func (c *staleCache) sweep(now time.Time) {
+	if now.Sub(c.swept) < c.ttl {
+		return
+	}
+	c.swept = now
+	for key, entry := range c.entries {
+		if now.Sub(entry.stored) >= c.ttl {
+			c.remove(key)
+		}
+	}
+}
+
+func (c *staleCache) oldest() string {
+	var key string
+	var stored time.Time
+	for k, entry := range c.entries {
+		if key == "" || entry.stored.Before(stored) {
+			key, stored = k, entry.stored
+		}
+	}
+	return key
+}
+
+func (c *staleCache) remove(key string) {
+	if entry, ok := c.entries[key]; ok {
+		c.size -= int64(len(entry.body))
+		delete(c.entries, key)
+	}
+}
+
+// serveStale returns the cached response of req when the backend of p is cold, waking it up in the background,
+// or nil when req should be forwarded.
+func (r *Retry) serveStale(req *http.Request, p *policy) *statusWriter {
+	if r.stale == nil || req.Method != http.MethodGet || r.isWarm(p.backend, r.clock.Now()) {
+		return nil
+	}
+	entry := r.stale.get(requestKey(req), r.clock.Now())
+	if entry == nil {
+		return nil
+	}
+
+	go r.wakeInBackground(r.delayFor(req, p), p)
+	sw := newStatusWriter()
+	copyHeader(sw.Header(), entry.header.Clone())
+	sw.Header().Set("X-Served-Stale", "true")
+	sw.WriteHeader(entry.status)
+	_, _ = sw.Write(entry.body)
+	return sw
+}
+
+// storeStale caches the response of a GET request, when it is complete and can be shared.
+func (r *Retry) storeStale(req *http.Request, sw *statusWriter) {
+	if r.stale == nil || req.Method != http.MethodGet || sw.committed || !shareable(sw) {
+		return
+	}
+	r.stale.put(requestKey(req), &staleEntry{
+		status: sw.status,
+		header: sw.header.Clone(),
+		body:   append([]byte(nil), sw.bodyBytes()...),
+		stored: r.clock.Now(),
+	})
+}
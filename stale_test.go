@@ -0,0 +1,152 @@
+package plugindemo_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/madshargreave/traefik-sleep"
+)
+
+func TestServeStaleWhileWaking(t *testing.T) {
+	tests := []struct {
+		desc    string
+		elapsed time.Duration
+		stale   bool
+	}{
+		{desc: "warm", elapsed: 30 * time.Second},
+		{desc: "cold", elapsed: 2 * time.Minute, stale: true},
+		{desc: "expired", elapsed: 20 * time.Minute},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			cfg := plugindemo.CreateConfig()
+			cfg.Attempts = 2
+			cfg.Delay = "1s"
+			cfg.WakeCacheTTL = "1m"
+			cfg.ServeStaleWhileWaking = true
+			cfg.StaleTTL = "10m"
+
+			var calls int32
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				call := atomic.AddInt32(&calls, 1)
+				rw.Header().Set("Content-Type", "text/plain")
+				if call == 1 {
+					_, _ = rw.Write([]byte("first"))
+					return
+				}
+				_, _ = rw.Write([]byte("fresh"))
+			})
+
+			handler, err := plugindemo.New(context.Background(), next, cfg, "demo-plugin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			clock := plugindemo.NewFakeClock(time.Now())
+			handler.(*plugindemo.Retry).SetClock(clock)
+
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost/page", nil))
+			clock.Advance(test.elapsed)
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://localhost/page", nil))
+
+			assertStatus(t, recorder, http.StatusOK)
+			if !test.stale {
+				assertHeader(t, recorder.Header(), "X-Served-Stale", "")
+				if body := recorder.Body.String(); body != "fresh" {
+					t.Errorf("invalid body: %q", body)
+				}
+				return
+			}
+			assertHeader(t, recorder.Header(), "X-Served-Stale", "true")
+			assertHeader(t, recorder.Header(), "Content-Type", "text/plain")
+			if body := recorder.Body.String(); body != "first" {
+				t.Errorf("invalid stale body: %q", body)
+			}
+			if c := atomic.LoadInt32(&calls); c != 1 {
+				t.Errorf("stale request forwarded: %d backend calls", c)
+			}
+		})
+	}
+}
+
+func TestServeStaleWhileWakingValidation(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 2
+	cfg.ServeStaleWhileWaking = true
+	cfg.StaleTTL = "10m"
+
+	if _, err := plugindemo.New(context.Background(), http.NotFoundHandler(), cfg, "demo-plugin"); err == nil {
+		t.Error("expected an error for serving stale responses without wake cache TTL")
+	}
+}
+
+func TestMaxStaleBytes(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 2
+	cfg.WakeCacheTTL = "1m"
+	cfg.ServeStaleWhileWaking = true
+	cfg.StaleTTL = "10m"
+	cfg.MaxStaleBytes = 4
+
+	calls := 0
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		_, _ = rw.Write([]byte("too large"))
+	})
+
+	handler, err := plugindemo.New(context.Background(), next, cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := plugindemo.NewFakeClock(time.Now())
+	handler.(*plugindemo.Retry).SetClock(clock)
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost/page", nil))
+	clock.Advance(2 * time.Minute)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://localhost/page", nil))
+
+	assertHeader(t, recorder.Header(), "X-Served-Stale", "")
+	if calls != 2 {
+		t.Errorf("invalid number of backend calls: %d", calls)
+	}
+}
+
+func TestStaleExpiry(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 1
+	cfg.WakeCacheTTL = "1m"
+	cfg.ServeStaleWhileWaking = true
+	cfg.StaleTTL = "10m"
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte("page"))
+	})
+
+	handler, err := plugindemo.New(context.Background(), next, cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	retry := handler.(*plugindemo.Retry)
+	clock := plugindemo.NewFakeClock(time.Now())
+	retry.SetClock(clock)
+
+	for i := 0; i < 10; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost/page/"+strconv.Itoa(i), nil))
+	}
+	clock.Advance(11 * time.Minute)
+
+	// The expired entries are removed when the next response is cached.
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost/page/0", nil))
+	if entries := retry.StaleEntries(); entries != 1 {
+		t.Errorf("expired entries kept: %d", entries)
+	}
+}
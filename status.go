@@ -0,0 +1,87 @@
+package plugindemo
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Status is the live state of a plugin instance, served by StatusHandler.
+type Status struct {
+	// InFlight is the number of requests being served.
+	InFlight int64 `json:"inFlight"`
+	// Sleeping is the number of requests waiting for their wake delay or backoff, when MaxSleepingRequests is set.
+	Sleeping    int64        `json:"sleeping"`
+	Circuit     CircuitState `json:"circuit"`
+	Maintenance bool         `json:"maintenance"`
+	Draining    bool         `json:"draining"`
+	// RetryBudgets is the number of retries made within the per IP window, by client IP.
+	RetryBudgets map[string]int `json:"retryBudgets,omitempty"`
+	// Warm is the time until which each backend is considered awake, the default one being named "default".
+	Warm    map[string]time.Time `json:"warm,omitempty"`
+	Metrics Metrics              `json:"metrics"`
+}
+
+// Status returns a snapshot of the live state of the plugin.
+func (r *Retry) Status() Status {
+	now := r.clock.Now()
+	return Status{
+		InFlight:     atomic.LoadInt64(&r.inFlight),
+		Sleeping:     atomic.LoadInt64(&r.sleeping),
+		Circuit:      r.circuit.currentState(now),
+		Maintenance:  atomic.LoadInt32(&r.maintenance) != 0,
+		Draining:     r.isDraining(),
+		RetryBudgets: r.budget.snapshot(now),
+		Warm:         r.warmSnapshot(now),
+		Metrics:      r.metrics.Snapshot(),
+	}
+}
+
+// StatusHandler returns a handler serving the live state of the plugin as JSON.
+// When an admin token is configured, the requests must carry it as a bearer token in their Authorization header.
+func (r *Retry) StatusHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if !r.authorized(req) {
+			rw.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(rw, http.StatusUnauthorized, "invalid admin token")
+			return
+		}
+
+		rw.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(rw).Encode(r.Status()); err != nil {
+			r.log.errorf("unable to write status: %v", err)
+		}
+	})
+}
+
+// authorized reports whether req carries the admin token, if any is configured.
+func (r *Retry) authorized(req *http.Request) bool {
+	if r.adminToken == "" {
+		return true
+	}
+	expected := "Bearer " + r.adminToken
+	return subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), []byte(expected)) == 1
+}
+
+// warmSnapshot returns the time until which each backend still warm at now is considered awake.
+func (r *Retry) warmSnapshot(now time.Time) map[string]time.Time {
+	r.warmMu.Lock()
+	defer r.warmMu.Unlock()
+
+	var warm map[string]time.Time
+	for backend, until := range r.warmUntil {
+		if !now.Before(until) {
+			continue
+		}
+		if warm == nil {
+			warm = make(map[string]time.Time)
+		}
+		if backend == defaultBackend {
+			backend = "default"
+		}
+		warm[backend] = until
+	}
+	return warm
+}
@@ -0,0 +1,110 @@
+package plugindemo_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/madshargreave/traefik-sleep"
+)
+
+func TestStatusHandler(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 2
+	cfg.PerIPRetryLimit = 10
+	cfg.PerIPWindow = "1m"
+	cfg.WakeCacheTTL = "1m"
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if strings.HasPrefix(req.Header.Get("X-Forwarded-For"), "10.0.0.1,") {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+	handler, err := plugindemo.New(context.Background(), next, cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	retry := handler.(*plugindemo.Retry)
+	clock := plugindemo.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	retry.SetClock(clock)
+
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2"} {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+		req.Header.Set("X-Forwarded-For", ip)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	recorder := httptest.NewRecorder()
+	retry.StatusHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://localhost/status", nil))
+
+	assertStatus(t, recorder, http.StatusOK)
+	assertHeader(t, recorder.Header(), "Content-Type", "application/json")
+
+	var status struct {
+		InFlight     *int64            `json:"inFlight"`
+		Circuit      string            `json:"circuit"`
+		RetryBudgets map[string]int    `json:"retryBudgets"`
+		Warm         map[string]string `json:"warm"`
+		Metrics      map[string]int64  `json:"metrics"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &status); err != nil {
+		t.Fatalf("invalid status %q: %v", recorder.Body.String(), err)
+	}
+
+	if status.InFlight == nil || *status.InFlight != 0 {
+		t.Errorf("invalid in flight count: %v", status.InFlight)
+	}
+	if status.Circuit != "closed" {
+		t.Errorf("invalid circuit state: %q", status.Circuit)
+	}
+	if len(status.RetryBudgets) != 1 || status.RetryBudgets["10.0.0.1"] != 1 {
+		t.Errorf("invalid retry budgets: %v", status.RetryBudgets)
+	}
+	if status.Warm["default"] != "2024-01-01T00:01:00Z" {
+		t.Errorf("invalid warm backends: %v", status.Warm)
+	}
+	if status.Metrics["requests"] != 2 || status.Metrics["retries"] != 1 || status.Metrics["retriesExhausted"] != 1 {
+		t.Errorf("invalid metrics: %v", status.Metrics)
+	}
+}
+
+func TestStatusHandlerAdminToken(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 2
+	cfg.AdminToken = "secret"
+
+	handler, err := plugindemo.New(context.Background(), http.NotFoundHandler(), cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := handler.(*plugindemo.Retry).StatusHandler()
+
+	testCases := []struct {
+		desc          string
+		authorization string
+		expected      int
+	}{
+		{desc: "absent", expected: http.StatusUnauthorized},
+		{desc: "wrong", authorization: "Bearer guess", expected: http.StatusUnauthorized},
+		{desc: "not bearer", authorization: "secret", expected: http.StatusUnauthorized},
+		{desc: "valid", authorization: "Bearer secret", expected: http.StatusOK},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/status", nil)
+			if test.authorization != "" {
+				req.Header.Set("Authorization", test.authorization)
+			}
+			recorder := httptest.NewRecorder()
+			status.ServeHTTP(recorder, req)
+
+			assertStatus(t, recorder, test.expected)
+		})
+	}
+}
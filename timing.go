@@ -0,0 +1,36 @@
+package plugindemo
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timing is the breakdown of the time spent serving a request.
+type timing struct {
+	// sleep is the time spent waiting for the backend to wake up.
+	sleep time.Duration
+	// backoff is the time spent waiting between the attempts.
+	backoff time.Duration
+	// backend is the time spent in the attempts.
+	backend time.Duration
+}
+
+// header returns the value of the Server-Timing header, the durations being in milliseconds.
+func (t timing) header() string {
+	metrics := []struct {
+		name     string
+		duration time.Duration
+	}{
+		{name: "sleep", duration: t.sleep},
+		{name: "backoff", duration: t.backoff},
+		{name: "backend", duration: t.backend},
+	}
+
+	values := make([]string, len(metrics))
+	for i, metric := range metrics {
+		ms := float64(metric.duration) / float64(time.Millisecond)
+		values[i] = metric.name + ";dur=" + strconv.FormatFloat(ms, 'f', -1, 64)
+	}
+	return strings.Join(values, ", ")
+}
@@ -0,0 +1,53 @@
+package plugindemo_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/madshargreave/traefik-sleep"
+)
+
+func TestServerTiming(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 2
+	cfg.Delay = "500ms"
+	cfg.BackoffBase = "300ms"
+	cfg.ServerTiming = true
+
+	clock := plugindemo.NewFakeClock(time.Now())
+	calls := 0
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		clock.Advance(60 * time.Millisecond)
+		if calls == 1 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+
+	handler, err := plugindemo.New(context.Background(), next, cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.(*plugindemo.Retry).SetClock(clock)
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+	assertStatus(t, recorder, http.StatusOK)
+	assertHeader(t, recorder.Header(), "Server-Timing", "sleep;dur=500, backoff;dur=300, backend;dur=120")
+}
+
+func TestServerTimingDisabled(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 2
+
+	recorder := serve(t, cfg, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}),
+		httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+	if value := recorder.Header().Get("Server-Timing"); value != "" {
+		t.Errorf("unexpected Server-Timing header: %q", value)
+	}
+}
@@ -0,0 +1,30 @@
+package plugindemo
+
+import "context"
+
+// Tracer starts spans around the requests served by the plugin and their attempts.
+// It is deliberately small, so that an OpenTelemetry tracer can be adapted to it
+// without the plugin depending on the OpenTelemetry modules.
+type Tracer interface {
+	// Start starts a span as a child of the span in ctx, if any,
+	// and returns a context holding the new span.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a traced operation.
+type Span interface {
+	SetAttribute(key string, value interface{})
+	End()
+}
+
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(key string, value interface{}) {}
+
+func (noopSpan) End() {}
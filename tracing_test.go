@@ -0,0 +1,106 @@
+package plugindemo_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/madshargreave/traefik-sleep"
+)
+
+type spanKey struct{}
+
+// recordingTracer keeps the spans it started in memory.
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordingSpan
+}
+
+type recordingSpan struct {
+	name       string
+	parent     *recordingSpan
+	attributes map[string]interface{}
+	ended      bool
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string) (context.Context, plugindemo.Span) {
+	parent, _ := ctx.Value(spanKey{}).(*recordingSpan)
+	span := &recordingSpan{name: name, parent: parent, attributes: map[string]interface{}{}}
+
+	t.mu.Lock()
+	t.spans = append(t.spans, span)
+	t.mu.Unlock()
+
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+func (s *recordingSpan) SetAttribute(key string, value interface{}) {
+	s.attributes[key] = value
+}
+
+func (s *recordingSpan) End() {
+	s.ended = true
+}
+
+func TestTracing(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 3
+	cfg.TracingEnabled = true
+
+	calls := 0
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		if calls < 3 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+
+	tracer := &recordingTracer{}
+	handler, err := plugindemo.NewWithTracer(context.Background(), next, cfg, "demo-plugin", tracer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+	if len(tracer.spans) != 4 {
+		t.Fatalf("invalid number of spans: %d", len(tracer.spans))
+	}
+
+	root := tracer.spans[0]
+	if root.name != "demo-plugin" || root.parent != nil || !root.ended {
+		t.Errorf("invalid request span: %+v", root)
+	}
+	if root.attributes["attempts"] != 3 || root.attributes["http.status_code"] != http.StatusOK {
+		t.Errorf("invalid request span attributes: %v", root.attributes)
+	}
+
+	expectedStatuses := []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusOK}
+	for i, span := range tracer.spans[1:] {
+		if span.name != "attempt" || span.parent != root || !span.ended {
+			t.Errorf("invalid attempt span: %+v", span)
+		}
+		if span.attributes["attempt"] != i+1 || span.attributes["http.status_code"] != expectedStatuses[i] {
+			t.Errorf("invalid attempt span attributes: %v", span.attributes)
+		}
+	}
+}
+
+func TestTracingDisabled(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 1
+
+	tracer := &recordingTracer{}
+	handler, err := plugindemo.NewWithTracer(context.Background(), http.NotFoundHandler(), cfg, "demo-plugin", tracer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+	if len(tracer.spans) != 0 {
+		t.Errorf("spans created while tracing is disabled: %d", len(tracer.spans))
+	}
+}
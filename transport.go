@@ -0,0 +1,76 @@
+package plugindemo
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// RetryTransport is an http.RoundTripper retrying the requests it sends as configured,
+// to reuse the retry logic of the plugin in an http.Client.
+// Responses are buffered in memory, and the failure to reach the server is reported as a 502.
+type RetryTransport struct {
+	retry *Retry
+}
+
+// NewRetryTransport creates a RetryTransport sending the requests through transport,
+// or http.DefaultTransport when nil.
+func NewRetryTransport(ctx context.Context, config *Config, transport http.RoundTripper) (*RetryTransport, error) {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	next := &roundTripHandler{transport: transport}
+	handler, err := New(ctx, next, config, "transport")
+	if err != nil {
+		return nil, err
+	}
+	next.log = handler.(*Retry).log
+	return &RetryTransport{retry: handler.(*Retry)}, nil
+}
+
+// RoundTrip sends req, retrying it as configured.
+func (t *RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+
+	sw := newStatusWriter()
+	res := t.retry.serve(sw, req, t.retry.policyFor(req))
+	t.retry.metrics.observe(res.attempts, res.exhausted)
+	res.sw.flush(sw)
+
+	status := sw.StatusCode()
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        sw.header,
+		Body:          ioutil.NopCloser(bytes.NewReader(sw.body.Bytes())),
+		ContentLength: int64(sw.body.Len()),
+		Request:       req,
+	}, nil
+}
+
+// roundTripHandler is the handler forwarding each attempt made by a RetryTransport to the server.
+type roundTripHandler struct {
+	transport http.RoundTripper
+	log       *levelLogger
+}
+
+func (h *roundTripHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	resp, err := h.transport.RoundTrip(req)
+	if err != nil {
+		h.log.warnf("attempt for request %v failed: %v", req.URL, err)
+		rw.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	copyHeader(rw.Header(), resp.Header)
+	rw.WriteHeader(resp.StatusCode)
+	_, _ = io.Copy(rw, resp.Body)
+}
@@ -0,0 +1,76 @@
+package plugindemo_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/madshargreave/traefik-sleep"
+)
+
+func TestRetryTransport(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		if calls <= 2 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		rw.Header().Set("X-Attempt", "3")
+		_, _ = rw.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 3
+
+	transport, err := plugindemo.NewRetryTransport(context.Background(), cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: transport}
+
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusOK || string(body) != "ok" {
+		t.Errorf("invalid response: %d %q", resp.StatusCode, body)
+	}
+	assertHeader(t, resp.Header, "X-Attempt", "3")
+	if calls != 3 {
+		t.Errorf("invalid number of attempts: %d", calls)
+	}
+}
+
+func TestRetryTransportUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 2
+
+	transport, err := plugindemo.NewRetryTransport(context.Background(), cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("invalid status: %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,194 @@
+package plugindemo
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// configErrors are all the errors found in a configuration.
+type configErrors []error
+
+func (e configErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// Unwrap returns the errors found in the configuration.
+func (e configErrors) Unwrap() []error {
+	return e
+}
+
+// Validate checks the configuration, as New does, returning all the errors found
+// joined in a single error, or nil when it is valid.
+// It does not take the environment overrides into account.
+func (c *Config) Validate() error {
+	var errs configErrors
+	add := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	_, err := newPolicies(c)
+	add(err)
+	add(validatePathPatterns(c.ExcludePaths))
+	add(validateLogFormat(c.LogFormat))
+	add(validateLogLevel(c.LogLevel))
+	add(validateOnSleepSaturated(c.OnSleepSaturated))
+	add(validateURL("health check URL", c.HealthCheckURL))
+	add(validateHeaderNames("health check headers", c.HealthCheckHeaders))
+	for _, fallbackURL := range fallbackURLs(c) {
+		add(validateURL("fallback URL", fallbackURL))
+	}
+	add(validateOnFull(c.OnFull))
+	for _, err := range c.validateDependencies() {
+		add(err)
+	}
+	for _, err := range c.validateLimits() {
+		add(err)
+	}
+	for _, err := range c.validateDurations() {
+		add(err)
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// validateDependencies checks the options requiring another one.
+func (c *Config) validateDependencies() []error {
+	var errs []error
+	if c.PrewarmOnStart && c.HealthCheckURL == "" {
+		errs = append(errs, errors.New("prewarm on start requires a health check URL"))
+	}
+	if c.KeepWarmInterval != "" && c.HealthCheckURL == "" {
+		errs = append(errs, errors.New("keep warm interval requires a health check URL"))
+	}
+	if c.WakeHoldingPage != "" {
+		errs = append(errs, validateHoldingPage(c))
+	}
+	if c.ServeStaleWhileWaking && (c.WakeCacheTTL == "" || c.StaleTTL == "") {
+		errs = append(errs, errors.New("serve stale while waking requires a wake cache TTL and a stale TTL"))
+	}
+	if c.AllowHeaderDelay && c.DelayHeader == "" {
+		errs = append(errs, errors.New("empty delay header"))
+	}
+	return errs
+}
+
+// validateLimits checks the numeric options.
+func (c *Config) validateLimits() []error {
+	var errs []error
+	for _, option := range []struct {
+		name  string
+		value int64
+	}{
+		{name: "max inspect bytes", value: c.MaxInspectBytes},
+		{name: "skip retry body bytes", value: c.SkipRetryBodyBytes},
+		{name: "shared attempts", value: int64(c.SharedAttempts)},
+		{name: "log sample rate", value: int64(c.LogSampleRate)},
+		{name: "max concurrent", value: int64(c.MaxConcurrent)},
+		{name: "max concurrent wakes", value: int64(c.MaxConcurrentWakes)},
+		{name: "max sleeping requests", value: int64(c.MaxSleepingRequests)},
+		{name: "event buffer size", value: int64(c.EventBufferSize)},
+		{name: "max stale bytes", value: c.MaxStaleBytes},
+		{name: "compress buffer threshold", value: c.CompressBufferThreshold},
+	} {
+		if option.value < 0 {
+			errs = append(errs, fmt.Errorf("incorrect value for %s (%d)", option.name, option.value))
+		}
+	}
+
+	if c.RetryProbability < 0 || c.RetryProbability > 1 {
+		errs = append(errs, fmt.Errorf("incorrect value for retry probability (%v)", c.RetryProbability))
+	}
+	if status := c.ErrorContractStatus; c.ErrorContract && !validStatus(status) {
+		errs = append(errs, fmt.Errorf("incorrect value for error contract status (%d)", status))
+	}
+	if status := c.RetriesExhaustedStatus; status != 0 && !validStatus(status) {
+		errs = append(errs, fmt.Errorf("incorrect value for retries exhausted status (%d)", status))
+	}
+	if status := c.MaintenanceStatus; status != 0 && !validStatus(status) {
+		errs = append(errs, fmt.Errorf("incorrect value for maintenance status (%d)", status))
+	}
+	if status := c.DrainingStatus; status != 0 && !validStatus(status) {
+		errs = append(errs, fmt.Errorf("incorrect value for draining status (%d)", status))
+	}
+	if _, err := parseStatusAttempts(c.StatusAttempts, c.MaxAttempts); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := newAdaptiveBackoff(c); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := newMemoryBudget(c.MaxTotalBufferBytes); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := newHistogram(c.AttemptLatencyBuckets); err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// validateDurations checks the duration options, and the options of the circuit breaker and of the per IP budget.
+func (c *Config) validateDurations() []error {
+	var errs []error
+	for _, option := range (&Retry{}).durationOptions(c) {
+		if _, err := parseDuration(option.name, option.value); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if _, err := parseAttemptTimeouts(c.AttemptTimeouts); err != nil {
+		errs = append(errs, err)
+	}
+
+	openDuration, err := parseDuration("open duration", c.OpenDuration)
+	if err != nil {
+		errs = append(errs, err)
+	} else if _, err := newCircuit(c.WindowSize, c.FailureThreshold, openDuration); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := parseDuration("stale TTL", c.StaleTTL); err != nil {
+		errs = append(errs, err)
+	}
+	if ttl, err := parseDuration("idempotency key TTL", c.IdempotencyKeyTTL); err != nil {
+		errs = append(errs, err)
+	} else if c.HonorIdempotencyKey && ttl <= 0 {
+		errs = append(errs, errors.New("honor idempotency key requires an idempotency key TTL"))
+	}
+	perIPWindow, err := parseDuration("per IP window", c.PerIPWindow)
+	if err != nil {
+		errs = append(errs, err)
+	} else if _, err := newRetryBudget(c.PerIPRetryLimit, perIPWindow); err != nil {
+		errs = append(errs, err)
+	}
+	retryBudgetWindow, err := parseDuration("retry budget window", c.RetryBudgetWindow)
+	if err != nil {
+		errs = append(errs, err)
+	} else if _, err := newRetryRatio(c.RetryBudgetPercent, c.MinRetriesPerSecond, retryBudgetWindow); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := newActiveHours(c); err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// statusOrUnavailable returns status, or 503 when it is zero.
+func statusOrUnavailable(status int) int {
+	if status == 0 {
+		return http.StatusServiceUnavailable
+	}
+	return status
+}
+
+// validStatus reports whether status is a valid HTTP status.
+func validStatus(status int) bool {
+	return status >= 100 && status <= 599
+}
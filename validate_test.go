@@ -0,0 +1,104 @@
+package plugindemo_test
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/madshargreave/traefik-sleep"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		desc     string
+		apply    func(cfg *plugindemo.Config)
+		expected []string
+	}{
+		{
+			desc:  "valid",
+			apply: func(cfg *plugindemo.Config) {},
+		},
+		{
+			desc: "attempts and duration",
+			apply: func(cfg *plugindemo.Config) {
+				cfg.Attempts = 20
+				cfg.Timeout = "soon"
+			},
+			expected: []string{
+				"value for attempt (20) exceeds the maximum (10)",
+				"incorrect value for timeout (soon)",
+			},
+		},
+		{
+			desc: "statuses",
+			apply: func(cfg *plugindemo.Config) {
+				cfg.MaintenanceStatus = 42
+				cfg.DrainingStatus = 700
+			},
+			expected: []string{
+				"incorrect value for maintenance status (42)",
+				"incorrect value for draining status (700)",
+			},
+		},
+		{
+			desc: "missing health check URL",
+			apply: func(cfg *plugindemo.Config) {
+				cfg.PrewarmOnStart = true
+				cfg.KeepWarmInterval = "1m"
+				cfg.MaxConcurrent = -1
+			},
+			expected: []string{
+				"prewarm on start requires a health check URL",
+				"keep warm interval requires a health check URL",
+				"incorrect value for max concurrent (-1)",
+			},
+		},
+		{
+			desc: "circuit breaker",
+			apply: func(cfg *plugindemo.Config) {
+				cfg.WindowSize = 10
+				cfg.FailureThreshold = 2
+				cfg.PerIPRetryLimit = 3
+			},
+			expected: []string{
+				"incorrect value for failure threshold (2)",
+				"empty per IP window",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			cfg := plugindemo.CreateConfig()
+			cfg.Attempts = 2
+			test.apply(cfg)
+
+			err := cfg.Validate()
+			if len(test.expected) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+
+			messages := strings.Split(err.Error(), "\n")
+			if len(messages) != len(test.expected) {
+				t.Fatalf("invalid errors: %q", messages)
+			}
+			for i, expected := range test.expected {
+				if !strings.HasPrefix(messages[i], expected) {
+					t.Errorf("invalid error %d: got %q, want %q", i, messages[i], expected)
+				}
+			}
+
+			if _, err := plugindemo.New(context.Background(), http.NotFoundHandler(), cfg, "demo-plugin"); err == nil {
+				t.Error("expected New to reject the configuration")
+			}
+		})
+	}
+}
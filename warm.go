@@ -0,0 +1,109 @@
+package plugindemo
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// awaken waits for the backend to wake up before forwarding req:
+// it sleeps for the delay and polls the health check,
+// unless the backend is known to still be warm from a previous request.
+// It reports whether the request had to wait.
+func (r *Retry) awaken(req *http.Request, p *policy) (bool, error) {
+	if r.isWarm(p.backend, r.clock.Now()) {
+		return false, nil
+	}
+	delay := r.delayFor(req, p)
+	if err := r.sleepRequest(req.Context(), delay); err != nil {
+		return true, err
+	}
+	return delay > 0 || p.healthCheckURL != "", r.wakeShared(req.Context(), p)
+}
+
+// warmState returns the value of the warm state header.
+func warmState(cold bool) string {
+	if cold {
+		return "cold"
+	}
+	return "warm"
+}
+
+// isWarm reports whether a request was successfully forwarded to backend within the wake cache TTL.
+func (r *Retry) isWarm(backend string, now time.Time) bool {
+	if r.wakeCacheTTL <= 0 {
+		return false
+	}
+
+	r.warmMu.Lock()
+	defer r.warmMu.Unlock()
+	return now.Before(r.warmUntil[backend])
+}
+
+// markWarm records that a request was successfully forwarded to backend at now.
+func (r *Retry) markWarm(backend string, now time.Time) {
+	if r.wakeCacheTTL <= 0 {
+		return
+	}
+
+	r.warmMu.Lock()
+	defer r.warmMu.Unlock()
+	if r.warmUntil == nil {
+		r.warmUntil = make(map[string]time.Time)
+	}
+	r.warmUntil[backend] = now.Add(r.wakeCacheTTL)
+}
+
+func validateHoldingPage(config *Config) error {
+	if config.HealthCheckURL == "" || config.WakeCacheTTL == "" {
+		return errors.New("wake holding page requires a health check URL and a wake cache TTL")
+	}
+	if status := config.WakeHoldingStatus; !validStatus(status) {
+		return fmt.Errorf("incorrect value for wake holding status (%d)", status)
+	}
+	return nil
+}
+
+// hold returns the holding page when configured and the backend is cold, waking it up in the background,
+// or nil when req should be forwarded.
+func (r *Retry) hold(req *http.Request, p *policy) *statusWriter {
+	if r.wakeHoldingPage == "" || r.isWarm(p.backend, r.clock.Now()) {
+		return nil
+	}
+	delay := r.delayFor(req, p)
+	go r.wakeInBackground(delay, p)
+	return r.holdingPage(delay)
+}
+
+// wakeInBackground wakes the backend of p after delay, once for all the concurrent requests, until the plugin is closed,
+// recording it as warm once healthy.
+func (r *Retry) wakeInBackground(delay time.Duration, p *policy) {
+	_ = r.wakes.do(r.ctx, p.healthCheckURL, func() error {
+		if err := r.sleep(r.ctx, delay); err != nil {
+			return err
+		}
+		if err := r.wake(r.ctx, p); err != nil {
+			return err
+		}
+		r.markWarm(p.backend, r.clock.Now())
+		return nil
+	})
+}
+
+// holdingPage returns the response used in place of waiting for a cold backend,
+// asking the client to come back once the backend should be awake after delay.
+func (r *Retry) holdingPage(delay time.Duration) *statusWriter {
+	seconds := int((delay + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	sw := newStatusWriter()
+	sw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	sw.Header().Set("Retry-After", strconv.Itoa(seconds))
+	sw.WriteHeader(r.wakeHoldingStatus)
+	_, _ = sw.Write([]byte(r.wakeHoldingPage))
+	return sw
+}
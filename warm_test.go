@@ -0,0 +1,150 @@
+package plugindemo_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/madshargreave/traefik-sleep"
+)
+
+func TestWakeCacheTTL(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 1
+	cfg.Delay = "2s"
+	cfg.WakeCacheTTL = "1m"
+
+	handler, err := plugindemo.New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}), cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := plugindemo.NewFakeClock(time.Now())
+	handler.(*plugindemo.Retry).SetClock(clock)
+
+	request := func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+	}
+
+	request()
+	if sleeps := clock.Sleeps(); len(sleeps) != 1 {
+		t.Fatalf("first request did not wait for the wake delay: %v", sleeps)
+	}
+
+	clock.Advance(30 * time.Second)
+	request()
+	if sleeps := clock.Sleeps(); len(sleeps) != 1 {
+		t.Fatalf("request within the wake cache TTL waited: %v", sleeps)
+	}
+
+	clock.Advance(time.Minute)
+	request()
+	if sleeps := clock.Sleeps(); len(sleeps) != 2 {
+		t.Fatalf("request after the wake cache TTL did not wait: %v", sleeps)
+	}
+}
+
+func TestWakeCacheTTLFailure(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 1
+	cfg.Delay = "2s"
+	cfg.WakeCacheTTL = "1m"
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	handler, err := plugindemo.New(context.Background(), next, cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := plugindemo.NewFakeClock(time.Now())
+	handler.(*plugindemo.Retry).SetClock(clock)
+
+	for i := 0; i < 2; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+	}
+
+	if sleeps := clock.Sleeps(); len(sleeps) != 2 {
+		t.Errorf("failed request marked the backend as warm: %v", sleeps)
+	}
+}
+
+func TestWarmStateHeader(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 1
+	cfg.Delay = "2s"
+	cfg.WakeCacheTTL = "1m"
+	cfg.WarmStateHeader = "X-Warm-State"
+
+	handler, err := plugindemo.New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}), cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.(*plugindemo.Retry).SetClock(plugindemo.NewFakeClock(time.Now()))
+
+	for _, expected := range []string{"cold", "warm"} {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+		assertHeader(t, recorder.Header(), "X-Warm-State", expected)
+	}
+}
+
+func TestWakeHoldingPage(t *testing.T) {
+	health := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}))
+	defer health.Close()
+
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 1
+	cfg.Delay = "200ms"
+	cfg.HealthCheckURL = health.URL
+	cfg.WakeCacheTTL = "1m"
+	cfg.WakeHoldingPage = "<p>Waking up</p>"
+
+	forwarded := make(chan struct{}, 10)
+	handler, err := plugindemo.New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		forwarded <- struct{}{}
+	}), cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = handler.(*plugindemo.Retry).Close() }()
+
+	start := time.Now()
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+	if elapsed := time.Since(start); elapsed >= 200*time.Millisecond {
+		t.Errorf("cold request blocked for %v", elapsed)
+	}
+	assertStatus(t, recorder, http.StatusServiceUnavailable)
+	assertHeader(t, recorder.Header(), "Retry-After", "1")
+	if recorder.Body.String() != "<p>Waking up</p>" {
+		t.Errorf("invalid body: %q", recorder.Body.String())
+	}
+	if len(forwarded) != 0 {
+		t.Error("cold request forwarded to the backend")
+	}
+
+	// The backend is warm once woken up in the background.
+	deadline := time.Now().Add(time.Second)
+	for len(forwarded) == 0 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+	}
+	if len(forwarded) == 0 {
+		t.Error("request not forwarded once the backend is warm")
+	}
+}
+
+func TestWakeHoldingPageRequirements(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 1
+	cfg.WakeHoldingPage = "<p>Waking up</p>"
+
+	if _, err := plugindemo.New(context.Background(), http.NotFoundHandler(), cfg, "demo-plugin"); err == nil {
+		t.Error("expected an error for a holding page without health check and wake cache")
+	}
+}
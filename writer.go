@@ -0,0 +1,402 @@
+package plugindemo
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+var errAbandoned = errors.New("attempt abandoned")
+
+// statusWriter buffers the response of a single attempt,
+// so that it can be dropped if the attempt is retried.
+// A response that cannot be retried anymore, or that is streamed,
+// is committed: it is written to the client response writer right away.
+type statusWriter struct {
+	header      http.Header
+	body        bytes.Buffer
+	status      int
+	length      int
+	wroteHeader bool
+
+	// rw is the client response writer the attempt is made on behalf of, if any.
+	rw        http.ResponseWriter
+	hijacked  bool
+	committed bool
+	// sent is set once some of the committed response was written to the client response writer.
+	sent bool
+	// writeErr is the error of a failed write to the client response writer, the following writes failing with it.
+	writeErr error
+	// retryable reports whether the response written so far may still be retried.
+	retryable func(sw *statusWriter) bool
+	// streamingContentTypes are the content types committed as soon as the header is written.
+	streamingContentTypes []string
+	// maxBufferBytes is the size above which the response is committed, unlimited when zero.
+	maxBufferBytes int64
+	// compressThreshold is the buffered size above which the body is kept gzip compressed, never when zero.
+	compressThreshold int64
+	// buffered is the uncompressed size of the buffered body.
+	buffered int64
+	// gz, once the body is compressed, compresses the following writes into compressed.
+	gz         *gzip.Writer
+	compressed bytes.Buffer
+	// memory is the reservation the buffered body is accounted in, the response being committed when it is exhausted.
+	memory *reservation
+	// reserved is the number of bytes this response reserved in memory.
+	reserved int64
+	// head is set on the response to a HEAD request, which has no body: the written bytes are discarded,
+	// the Content-Length set by the handler being kept.
+	head bool
+	// stripHeaders are the headers removed from the response sent to the client.
+	stripHeaders []string
+
+	// mu guards the writes of an attempt that may be abandoned while its handler is still running.
+	mu sync.Mutex
+	// started, when not nil, is closed once the header is written.
+	started   chan struct{}
+	abandoned bool
+	// noResponse is set on the response used in place of an attempt that got none,
+	// because its handler panicked or was abandoned.
+	noResponse bool
+	panicked   bool
+}
+
+func newStatusWriter() *statusWriter {
+	return &statusWriter{header: make(http.Header)}
+}
+
+// newAttemptWriter returns a statusWriter buffering an attempt made on behalf of rw.
+func newAttemptWriter(rw http.ResponseWriter, streamingContentTypes []string, retryable func(sw *statusWriter) bool) *statusWriter {
+	sw := newStatusWriter()
+	sw.rw = rw
+	sw.retryable = retryable
+	sw.streamingContentTypes = streamingContentTypes
+	return sw
+}
+
+func (w *statusWriter) Header() http.Header {
+	return w.header
+}
+
+// WriteHeader records the status of the response.
+// Only the first valid call is taken into account, as with a regular http.ResponseWriter.
+// The informational statuses but 101 are passed through to the client instead,
+// without being recorded as the status of the response.
+func (w *statusWriter) WriteHeader(status int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.abandoned {
+		w.writeHeader(status)
+	}
+}
+
+func (w *statusWriter) writeHeader(status int) {
+	if w.wroteHeader || status < 100 || status > 999 {
+		return
+	}
+	if isInformational(status) {
+		w.writeInformational(status)
+		return
+	}
+	w.status = status
+	w.wroteHeader = true
+	if w.started != nil {
+		close(w.started)
+	}
+
+	if w.rw != nil && w.isStreaming() {
+		w.commit()
+	}
+}
+
+// isInformational reports whether status is an informational status followed by the final one.
+func isInformational(status int) bool {
+	return status < http.StatusOK && status != http.StatusSwitchingProtocols
+}
+
+// writeInformational sends an informational response to the client, along with the header written so far.
+// The header is not kept on the client response writer, so that a retried attempt does not leak it.
+func (w *statusWriter) writeInformational(status int) {
+	if w.rw == nil || w.committed || w.hijacked {
+		return
+	}
+
+	dst := w.rw.Header()
+	previous := dst.Clone()
+	w.copyHeaderTo(dst)
+	w.rw.WriteHeader(status)
+	for key := range dst {
+		delete(dst, key)
+	}
+	copyHeader(dst, previous)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.abandoned {
+		return 0, errAbandoned
+	}
+	if w.writeErr != nil {
+		return 0, w.writeErr
+	}
+	if !w.wroteHeader {
+		w.writeHeader(http.StatusOK)
+	}
+	if w.head {
+		// The body of a response to a HEAD request is dropped, even when the handler writes one.
+		return len(b), nil
+	}
+	if !w.committed && w.rw != nil && !w.canBuffer(len(b)) {
+		w.commit()
+	}
+
+	var n int
+	var err error
+	if w.committed {
+		n, err = w.rw.Write(b)
+		w.wrote(n, err)
+	} else {
+		n, err = w.buffer(b)
+	}
+	w.length += n
+	return n, err
+}
+
+// wrote records the outcome of a write of n bytes to the client response writer.
+func (w *statusWriter) wrote(n int, err error) {
+	if n > 0 {
+		w.sent = true
+	}
+	if err != nil && w.writeErr == nil {
+		w.writeErr = err
+	}
+}
+
+// canBuffer reports whether n more bytes can be buffered,
+// within both the max buffer bytes and the memory reservation.
+func (w *statusWriter) canBuffer(n int) bool {
+	if w.maxBufferBytes > 0 && w.buffered+int64(n) > w.maxBufferBytes {
+		return false
+	}
+	if !w.memory.reserve(n) {
+		return false
+	}
+	w.reserved += int64(n)
+	return true
+}
+
+// releaseMemory gives back the memory reserved by the response of an attempt that is dropped.
+func (w *statusWriter) releaseMemory() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.memory.free(w.reserved)
+	w.reserved = 0
+}
+
+// Flush sends the response written so far to the client, if the response cannot be retried anymore.
+// Otherwise, it keeps being buffered until the end of the attempt.
+func (w *statusWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.rw == nil || w.hijacked || w.abandoned {
+		return
+	}
+
+	if !w.committed {
+		if !w.wroteHeader {
+			w.writeHeader(http.StatusOK)
+		}
+		if w.retryable != nil && w.retryable(w) {
+			return
+		}
+		w.commit()
+	}
+
+	if flusher, ok := w.rw.(http.Flusher); ok {
+		flusher.Flush()
+		w.sent = true
+	}
+}
+
+// isStreaming reports whether the content type of the response is one of the streaming ones.
+func (w *statusWriter) isStreaming() bool {
+	return hasContentType(w.header, w.streamingContentTypes)
+}
+
+// hasContentType reports whether the media type of the Content-Type header is one of contentTypes.
+func hasContentType(header http.Header, contentTypes []string) bool {
+	if len(contentTypes) == 0 {
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	for _, contentType := range contentTypes {
+		if strings.EqualFold(mediaType, contentType) {
+			return true
+		}
+	}
+	return false
+}
+
+// commit writes the buffered response to the client response writer,
+// the following writes going straight to it.
+func (w *statusWriter) commit() {
+	if w.committed {
+		return
+	}
+	w.committed = true
+
+	w.copyHeaderTo(w.rw.Header())
+	w.rw.WriteHeader(w.status)
+	if w.buffered > 0 {
+		w.wrote(w.writeBodyTo(w.rw))
+		w.resetBody()
+	}
+}
+
+// Hijack lets the handler take over the client connection, if the client response writer supports it.
+// The buffered response is dropped once the connection is hijacked.
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.abandoned {
+		return nil, nil, errAbandoned
+	}
+	hijacker, ok := w.rw.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("%T is not a http.Hijacker", w.rw)
+	}
+
+	conn, brw, err := hijacker.Hijack()
+	if err == nil {
+		w.hijacked = true
+	}
+	return conn, brw, err
+}
+
+// abandon makes the writer drop all the following writes, unless the header was already written,
+// and reports whether it did.
+func (w *statusWriter) abandon() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.wroteHeader || w.hijacked {
+		return false
+	}
+	w.abandoned = true
+	return true
+}
+
+// discard makes the writer drop the buffered response and all the following writes,
+// unless the response was already committed, and reports whether it did.
+func (w *statusWriter) discard() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.committed || w.hijacked {
+		return false
+	}
+	w.abandoned = true
+	w.resetBody()
+	return true
+}
+
+// connectionError reports whether the attempt failed without a response from the backend:
+// its handler either panicked, was abandoned, or returned without writing anything.
+func (w *statusWriter) connectionError() bool {
+	return w.noResponse || !w.wroteHeader && !w.hijacked
+}
+
+// StatusCode returns the status of the response, defaulting to 200 when none was written.
+func (w *statusWriter) StatusCode() int {
+	if !w.wroteHeader {
+		return http.StatusOK
+	}
+
+	return w.status
+}
+
+// flush writes the buffered response to rw, unless it was already committed or the connection was hijacked,
+// followed by its trailers.
+func (w *statusWriter) flush(rw http.ResponseWriter) {
+	if w.hijacked {
+		return
+	}
+	if w.committed {
+		w.copyTrailersTo(w.rw.Header())
+		return
+	}
+
+	w.copyHeaderTo(rw.Header())
+
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	rw.WriteHeader(w.status)
+
+	_, _ = w.writeBodyTo(rw)
+	w.copyTrailersTo(rw.Header())
+}
+
+// copyHeaderTo copies the header of the response to dst, without the stripped headers,
+// nor the declared trailers, which are only copied once the body is written.
+func (w *statusWriter) copyHeaderTo(dst http.Header) {
+	trailers := w.declaredTrailers()
+	for key, values := range w.header {
+		if !trailers[key] {
+			dst[key] = values
+		}
+	}
+	for _, key := range w.stripHeaders {
+		dst.Del(key)
+	}
+}
+
+// copyTrailersTo copies the trailers of the response to dst, after the body was written:
+// the ones declared in the Trailer header, and the ones with the http.TrailerPrefix.
+func (w *statusWriter) copyTrailersTo(dst http.Header) {
+	trailers := w.declaredTrailers()
+	for key, values := range w.header {
+		if trailers[key] || strings.HasPrefix(key, http.TrailerPrefix) {
+			dst[key] = values
+		}
+	}
+}
+
+// declaredTrailers returns the set of the trailers declared in the Trailer header, nil when there is none.
+func (w *statusWriter) declaredTrailers() map[string]bool {
+	var trailers map[string]bool
+	for _, value := range w.header.Values("Trailer") {
+		for _, key := range strings.Split(value, ",") {
+			if key = strings.TrimSpace(key); key != "" {
+				if trailers == nil {
+					trailers = make(map[string]bool)
+				}
+				trailers[http.CanonicalHeaderKey(key)] = true
+			}
+		}
+	}
+	return trailers
+}
+
+func copyHeader(dst, src http.Header) {
+	for key, values := range src {
+		dst[key] = values
+	}
+}
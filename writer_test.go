@@ -0,0 +1,651 @@
+package plugindemo_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/madshargreave/traefik-sleep"
+)
+
+func TestWriterStatus(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		handler  http.HandlerFunc
+		expected int
+		attempts int
+	}{
+		{
+			desc: "double WriteHeader",
+			handler: func(rw http.ResponseWriter, req *http.Request) {
+				rw.WriteHeader(http.StatusCreated)
+				rw.WriteHeader(http.StatusServiceUnavailable)
+			},
+			expected: http.StatusCreated,
+			attempts: 1,
+		},
+		{
+			desc: "bare Write",
+			handler: func(rw http.ResponseWriter, req *http.Request) {
+				_, _ = rw.Write([]byte("ok"))
+				rw.WriteHeader(http.StatusServiceUnavailable)
+			},
+			expected: http.StatusOK,
+			attempts: 1,
+		},
+		{
+			desc: "invalid WriteHeader",
+			handler: func(rw http.ResponseWriter, req *http.Request) {
+				rw.WriteHeader(0)
+				rw.WriteHeader(http.StatusServiceUnavailable)
+			},
+			expected: http.StatusServiceUnavailable,
+			attempts: 2,
+		},
+		{
+			desc:     "nothing written",
+			handler:  func(rw http.ResponseWriter, req *http.Request) {},
+			expected: http.StatusOK,
+			attempts: 1,
+		},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			cfg := plugindemo.CreateConfig()
+			cfg.Attempts = 2
+
+			calls := 0
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				test.handler(rw, req)
+			})
+
+			recorder := serve(t, cfg, next, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+			assertStatus(t, recorder, test.expected)
+			if calls != test.attempts {
+				t.Errorf("invalid number of attempts: %d", calls)
+			}
+		})
+	}
+}
+
+// hijackRecorder is a response recorder supporting connection hijacking.
+type hijackRecorder struct {
+	*httptest.ResponseRecorder
+	hijacked bool
+}
+
+func (r *hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	r.hijacked = true
+	client, server := net.Pipe()
+	_ = client.Close()
+	return server, bufio.NewReadWriter(bufio.NewReader(server), bufio.NewWriter(server)), nil
+}
+
+func TestUpgradePassthrough(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 3
+
+	recorder := &hijackRecorder{ResponseRecorder: httptest.NewRecorder()}
+	calls := 0
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		if rw != recorder {
+			t.Errorf("upgrade request was buffered: %T", rw)
+		}
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.Header.Set("Connection", "keep-alive, Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	serveHandler(t, cfg, next, recorder, req)
+
+	if calls != 1 {
+		t.Errorf("upgrade request was retried: %d attempts", calls)
+	}
+}
+
+func TestHijack(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 3
+
+	recorder := &hijackRecorder{ResponseRecorder: httptest.NewRecorder()}
+	calls := 0
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		hijacker, ok := rw.(http.Hijacker)
+		if !ok {
+			t.Fatal("response writer is not a http.Hijacker")
+		}
+		conn, _, err := hijacker.Hijack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.Close()
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	serveHandler(t, cfg, next, recorder, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+	if !recorder.hijacked {
+		t.Error("connection was not hijacked")
+	}
+	if calls != 1 {
+		t.Errorf("hijacked request was retried: %d attempts", calls)
+	}
+	if recorder.Flushed || recorder.Body.Len() != 0 {
+		t.Error("response written to a hijacked connection")
+	}
+}
+
+func TestHijackUnsupported(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 1
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if _, _, err := rw.(http.Hijacker).Hijack(); err == nil {
+			t.Error("expected an error when the client response writer cannot be hijacked")
+		}
+	})
+
+	serve(t, cfg, next, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+}
+
+func TestFlushStreaming(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		attempts    int
+		contentType string
+		status      int
+		expected    int
+	}{
+		{desc: "streaming content type", attempts: 3, contentType: "text/event-stream; charset=utf-8", status: http.StatusServiceUnavailable, expected: 1},
+		{desc: "last attempt", attempts: 1, contentType: "text/plain", status: http.StatusOK, expected: 1},
+		{desc: "non retryable status", attempts: 3, contentType: "text/plain", status: http.StatusOK, expected: 1},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			cfg := plugindemo.CreateConfig()
+			cfg.Attempts = test.attempts
+			cfg.StreamingContentTypes = []string{"text/event-stream"}
+
+			recorder := httptest.NewRecorder()
+			calls := 0
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				rw.Header().Set("Content-Type", test.contentType)
+				rw.WriteHeader(test.status)
+				for _, chunk := range []string{"a", "b", "c"} {
+					before := recorder.Body.String()
+					_, _ = rw.Write([]byte(chunk))
+					rw.(http.Flusher).Flush()
+					if recorder.Body.String() != before+chunk {
+						t.Fatalf("chunk %q was not streamed, client received %q", chunk, recorder.Body.String())
+					}
+				}
+			})
+
+			serveHandler(t, cfg, next, recorder, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+			assertStatus(t, recorder, test.status)
+			if recorder.Body.String() != "abc" {
+				t.Errorf("invalid body: %q", recorder.Body.String())
+			}
+			if !recorder.Flushed {
+				t.Error("response was not flushed")
+			}
+			if calls != test.expected {
+				t.Errorf("invalid number of attempts: %d", calls)
+			}
+		})
+	}
+}
+
+func TestFlushRetryable(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 2
+
+	recorder := httptest.NewRecorder()
+	calls := 0
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		if calls == 1 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = rw.Write([]byte("unavailable"))
+			rw.(http.Flusher).Flush()
+			if recorder.Body.Len() != 0 {
+				t.Errorf("retryable response was flushed: %q", recorder.Body.String())
+			}
+			return
+		}
+		_, _ = rw.Write([]byte("ok"))
+	})
+
+	serveHandler(t, cfg, next, recorder, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+	assertStatus(t, recorder, http.StatusOK)
+	if recorder.Body.String() != "ok" {
+		t.Errorf("invalid body: %q", recorder.Body.String())
+	}
+}
+
+func TestMaxResponseBufferBytes(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 3
+	cfg.MaxResponseBufferBytes = 8
+
+	calls := 0
+	recorder := httptest.NewRecorder()
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = rw.Write([]byte("small"))
+		if recorder.Body.Len() != 0 {
+			t.Error("response sent before exceeding the buffer limit")
+		}
+		_, _ = rw.Write([]byte(" and large"))
+		if recorder.Body.String() != "small and large" {
+			t.Errorf("response not sent once exceeding the buffer limit: %q", recorder.Body.String())
+		}
+	})
+
+	serveHandler(t, cfg, next, recorder, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+	assertStatus(t, recorder, http.StatusServiceUnavailable)
+	if calls != 1 {
+		t.Errorf("invalid number of attempts: %d", calls)
+	}
+}
+
+func TestMaxTotalBufferBytes(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 2
+	cfg.MaxTotalBufferBytes = 100
+
+	buffered := make(chan struct{})
+	release := make(chan struct{})
+	calls := map[string]int{}
+	var mu sync.Mutex
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		calls[req.URL.Path]++
+		call := calls[req.URL.Path]
+		mu.Unlock()
+
+		switch req.URL.Path {
+		case "/hold":
+			_, _ = rw.Write(bytes.Repeat([]byte("a"), 80))
+			close(buffered)
+			<-release
+		default:
+			if call == 1 {
+				rw.WriteHeader(http.StatusServiceUnavailable)
+				_, _ = rw.Write(bytes.Repeat([]byte("b"), 80))
+			}
+		}
+	})
+
+	handler, err := plugindemo.New(context.Background(), next, cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	held := make(chan *httptest.ResponseRecorder)
+	go func() {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://localhost/hold", nil))
+		held <- recorder
+	}()
+	<-buffered
+
+	// The budget is mostly used by the held request: the response is sent as is, without retry.
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://localhost/excess", nil))
+	assertStatus(t, recorder, http.StatusServiceUnavailable)
+	if calls["/excess"] != 1 {
+		t.Errorf("request beyond the budget retried: %d attempts", calls["/excess"])
+	}
+
+	close(release)
+	assertStatus(t, <-held, http.StatusOK)
+
+	// The budget is released once the held request was served.
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://localhost/within", nil))
+	assertStatus(t, recorder, http.StatusOK)
+	if calls["/within"] != 2 {
+		t.Errorf("request within the budget not retried: %d attempts", calls["/within"])
+	}
+}
+
+func TestMaxTotalBufferBytesRetries(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 4
+	cfg.MaxTotalBufferBytes = 250
+
+	calls := 0
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		if calls < 4 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_, _ = rw.Write(bytes.Repeat([]byte("a"), 100))
+	})
+
+	// Each dropped attempt gives its memory back: the attempts never use more than 100 bytes at once.
+	recorder := serve(t, cfg, next, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+	assertStatus(t, recorder, http.StatusOK)
+	if calls != 4 {
+		t.Errorf("invalid number of attempts: %d", calls)
+	}
+}
+
+func TestInvalidMaxTotalBufferBytes(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 2
+	cfg.MaxTotalBufferBytes = -1
+
+	if _, err := plugindemo.New(context.Background(), http.NotFoundHandler(), cfg, "demo-plugin"); err == nil {
+		t.Error("expected an error for an invalid max total buffer bytes")
+	}
+}
+
+func TestInformationalPassthrough(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 2
+
+	calls := 0
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		rw.Header().Set("Link", "</style.css>; rel=preload")
+		rw.WriteHeader(http.StatusEarlyHints)
+		rw.Header().Del("Link")
+		rw.WriteHeader(http.StatusOK)
+		_, _ = rw.Write([]byte("ok"))
+	})
+
+	handler, err := plugindemo.New(context.Background(), next, cfg, "demo-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	var informational []int
+	var links []string
+	trace := &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			informational = append(informational, code)
+			links = append(links, header.Get("Link"))
+			return nil
+		},
+	}
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(context.Background(), trace), http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("invalid status: %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Link") != "" {
+		t.Errorf("early hint leaked into the final response: %v", resp.Header)
+	}
+	if len(informational) != 1 || informational[0] != http.StatusEarlyHints || links[0] != "</style.css>; rel=preload" {
+		t.Errorf("invalid informational responses: %v %v", informational, links)
+	}
+	if calls != 1 {
+		t.Errorf("informational status retried: %d attempts", calls)
+	}
+}
+
+var errBroken = errors.New("broken pipe")
+
+// brokenWriter is a response writer whose first writes fail.
+// Like the net/http one, it only sends the status along with the first successful write.
+type brokenWriter struct {
+	*httptest.ResponseRecorder
+	failures int
+	pending  int
+}
+
+func (w *brokenWriter) WriteHeader(status int) {
+	w.pending = status
+}
+
+func (w *brokenWriter) Write(b []byte) (int, error) {
+	if w.failures > 0 {
+		w.failures--
+		return 0, errBroken
+	}
+	if w.pending != 0 {
+		w.ResponseRecorder.WriteHeader(w.pending)
+	}
+	return w.ResponseRecorder.Write(b)
+}
+
+func TestWriteError(t *testing.T) {
+	tests := []struct {
+		desc     string
+		retry    bool
+		status   int
+		body     string
+		attempts int
+	}{
+		{desc: "retried", retry: true, status: http.StatusOK, body: "complete", attempts: 2},
+		{desc: "not retried", status: http.StatusBadGateway, attempts: 1},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			cfg := plugindemo.CreateConfig()
+			cfg.Attempts = 2
+			cfg.RetryOnErrorOnly = true
+			cfg.RetryOnError = test.retry
+			cfg.MaxResponseBufferBytes = 4
+
+			calls := 0
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				if _, err := rw.Write([]byte("complete")); err != nil {
+					if _, err := rw.Write([]byte("rest")); !errors.Is(err, errBroken) {
+						t.Errorf("write after a failed one did not fail: %v", err)
+					}
+				}
+			})
+
+			recorder := &brokenWriter{ResponseRecorder: httptest.NewRecorder(), failures: 1}
+			serveHandler(t, cfg, next, recorder, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+			assertStatus(t, recorder.ResponseRecorder, test.status)
+			if body := recorder.Body.String(); body != test.body {
+				t.Errorf("invalid body: %q", body)
+			}
+			if calls != test.attempts {
+				t.Errorf("invalid number of attempts: %d", calls)
+			}
+		})
+	}
+}
+
+func TestTrailers(t *testing.T) {
+	tests := []struct {
+		desc      string
+		prefix    bool
+		committed bool
+		expected  string
+	}{
+		{desc: "declared", expected: "2"},
+		{desc: "trailer prefix", prefix: true, expected: "2"},
+		{desc: "declared committed", committed: true, expected: "1"},
+		{desc: "trailer prefix committed", prefix: true, committed: true, expected: "1"},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			cfg := plugindemo.CreateConfig()
+			cfg.Attempts = 2
+			if test.committed {
+				cfg.MaxResponseBufferBytes = 1
+			}
+
+			calls := 0
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				if !test.prefix {
+					rw.Header().Set("Trailer", "X-Checksum")
+				}
+				if calls == 1 && !test.committed {
+					rw.WriteHeader(http.StatusServiceUnavailable)
+				}
+				_, _ = rw.Write([]byte("body"))
+				if test.committed {
+					// Like net/http, the committed response only sends the undeclared trailers once chunked.
+					rw.(http.Flusher).Flush()
+				}
+				if test.prefix {
+					rw.Header().Set(http.TrailerPrefix+"X-Checksum", strconv.Itoa(calls))
+				} else {
+					rw.Header().Set("X-Checksum", strconv.Itoa(calls))
+				}
+			})
+
+			handler, err := plugindemo.New(context.Background(), next, cfg, "demo-plugin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			server := httptest.NewServer(handler)
+			defer server.Close()
+
+			resp, err := http.Get(server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := ioutil.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if resp.StatusCode != http.StatusOK || string(body) != "body" {
+				t.Errorf("invalid response: %d %q", resp.StatusCode, body)
+			}
+			if value := resp.Trailer.Get("X-Checksum"); value != test.expected {
+				t.Errorf("invalid trailer: %q (%v)", value, resp.Trailer)
+			}
+			if value := resp.Header.Get("X-Checksum"); value != "" {
+				t.Errorf("trailer sent as a header: %q", value)
+			}
+		})
+	}
+}
+
+func TestCompressBuffer(t *testing.T) {
+	var body bytes.Buffer
+	for i := 0; body.Len() < 1<<20; i++ {
+		body.WriteString("line " + strconv.Itoa(i*i) + " of the large response body\n")
+	}
+
+	tests := []struct {
+		desc                   string
+		maxResponseBufferBytes int64
+		retryOnBodyContains    []string
+		expCalls               int
+	}{
+		{desc: "buffered", expCalls: 2},
+		{desc: "committed past the max response buffer bytes", maxResponseBufferBytes: 256 << 10, expCalls: 1},
+		{desc: "inspected body", retryOnBodyContains: []string{"starting"}, expCalls: 2},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			cfg := plugindemo.CreateConfig()
+			cfg.Attempts = 2
+			cfg.CompressBuffer = true
+			cfg.CompressBufferThreshold = 1024
+			cfg.MaxResponseBufferBytes = test.maxResponseBufferBytes
+			cfg.RetryOnBodyContains = test.retryOnBodyContains
+
+			calls := 0
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				if calls == 1 && test.retryOnBodyContains == nil {
+					rw.WriteHeader(http.StatusServiceUnavailable)
+				}
+				data := body.Bytes()
+				if calls == 1 && test.retryOnBodyContains != nil {
+					// The marker is written past the compress threshold.
+					data = append(append([]byte(nil), data[:2048]...), "starting"...)
+				}
+				for len(data) > 0 {
+					n := 4000
+					if n > len(data) {
+						n = len(data)
+					}
+					_, _ = rw.Write(data[:n])
+					data = data[n:]
+				}
+			})
+
+			recorder := serve(t, cfg, next, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+			if calls != test.expCalls {
+				t.Errorf("invalid number of attempts: %d", calls)
+			}
+			if test.expCalls == 2 {
+				assertStatus(t, recorder, http.StatusOK)
+			}
+			if test.expCalls == 1 {
+				assertStatus(t, recorder, http.StatusServiceUnavailable)
+			}
+			if !bytes.Equal(recorder.Body.Bytes(), body.Bytes()) {
+				t.Errorf("invalid body of %d bytes, expected %d bytes", recorder.Body.Len(), body.Len())
+			}
+		})
+	}
+}
+
+func TestHeadBodyDiscarded(t *testing.T) {
+	cfg := plugindemo.CreateConfig()
+	cfg.Attempts = 2
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Length", "11")
+		rw.Header().Set("X-Demo", "head")
+		_, _ = rw.Write([]byte("not allowed"))
+	})
+
+	recorder := serve(t, cfg, next, httptest.NewRequest(http.MethodHead, "http://localhost", nil))
+
+	assertStatus(t, recorder, http.StatusOK)
+	assertHeader(t, recorder.Header(), "Content-Length", "11")
+	assertHeader(t, recorder.Header(), "X-Demo", "head")
+	if recorder.Body.Len() != 0 {
+		t.Errorf("body sent for a HEAD request: %q", recorder.Body.String())
+	}
+}